@@ -0,0 +1,255 @@
+package main
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// caValidity and leafValidity bound the lifetime of the root CA this proxy
+// mints on first run, and of the per-host leaf certificates it signs with
+// it, respectively.
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 30 * 24 * time.Hour
+
+	maxLeafCacheSize = 256
+)
+
+// CertAuthority lets the proxy terminate TLS for CONNECT tunnels so the
+// usual caching/Vary logic in handleRequest can see the decrypted request.
+// It holds the root CA loaded (or generated) at startup, plus an LRU of
+// per-host leaf certificates minted on demand and signed by that CA.
+type CertAuthority struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+
+	mu       sync.Mutex
+	leaves   map[string]*tls.Certificate
+	lru      *list.List
+	lruIndex map[string]*list.Element
+}
+
+// defaultCAPaths returns the default ca.crt/ca.key locations under
+// ~/.caching-proxy, used when --ca-cert/--ca-key are left unset.
+func defaultCAPaths() (certPath, keyPath string, err error) {
+	dir, err := defaultStoreDir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), nil
+}
+
+// LoadOrCreateCertAuthority loads a root CA from certPath/keyPath, or
+// generates a new self-signed one and writes it to those paths if either
+// file is absent. Installing the resulting ca.crt in a client's trust
+// store is what lets that client accept the leaf certificates this proxy
+// mints for intercepted HTTPS origins.
+func LoadOrCreateCertAuthority(certPath, keyPath string) (*CertAuthority, error) {
+	if certPath == "" || keyPath == "" {
+		defaultCertPath, defaultKeyPath, err := defaultCAPaths()
+		if err != nil {
+			return nil, err
+		}
+		if certPath == "" {
+			certPath = defaultCertPath
+		}
+		if keyPath == "" {
+			keyPath = defaultKeyPath
+		}
+	}
+
+	if certPEM, keyPEM, err := readPEMPair(certPath, keyPath); err == nil {
+		return newCertAuthority(certPEM, keyPEM)
+	}
+
+	certPEM, keyPEM, err := generateCA()
+	if err != nil {
+		return nil, fmt.Errorf("generate CA: %v", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return nil, fmt.Errorf("write CA cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return nil, fmt.Errorf("write CA key: %v", err)
+	}
+	return newCertAuthority(certPEM, keyPEM)
+}
+
+func readPEMPair(certPath, keyPath string) (certPEM, keyPEM []byte, err error) {
+	certPEM, err = os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+func generateCA() (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "caching-proxy local CA",
+			Organization: []string{"caching-proxy"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+func newCertAuthority(certPEM, keyPEM []byte) (*CertAuthority, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("decode CA cert: no PEM block found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA cert: %v", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("decode CA key: no PEM block found")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key: %v", err)
+	}
+
+	return &CertAuthority{
+		cert:     cert,
+		key:      key,
+		leaves:   make(map[string]*tls.Certificate),
+		lru:      list.New(),
+		lruIndex: make(map[string]*list.Element),
+	}, nil
+}
+
+// GetCertificate is used as a tls.Config.GetCertificate callback: it mints
+// (or returns a cached) leaf certificate for the SNI host the client asked
+// for.
+func (ca *CertAuthority) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		return nil, fmt.Errorf("no SNI host presented")
+	}
+	return ca.leafFor(host)
+}
+
+// GetCertificateForHost mints (or returns a cached) leaf certificate for
+// host directly, bypassing the SNI requirement GetCertificate enforces.
+// handleConnect uses this as a fallback to the CONNECT target when the
+// client's ClientHello carries no SNI extension at all, which crypto/tls
+// omits whenever the dialed host is an IP literal (RFC 6066) — exactly the
+// case for IP-addressed origins such as httptest.NewTLSServer.
+func (ca *CertAuthority) GetCertificateForHost(host string) (*tls.Certificate, error) {
+	return ca.leafFor(host)
+}
+
+func (ca *CertAuthority) leafFor(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	if leaf, ok := ca.leaves[host]; ok {
+		ca.lru.MoveToFront(ca.lruIndex[host])
+		ca.mu.Unlock()
+		return leaf, nil
+	}
+	ca.mu.Unlock()
+
+	leaf, err := ca.mintLeaf(host)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.leaves[host] = leaf
+	ca.lruIndex[host] = ca.lru.PushFront(host)
+	for len(ca.leaves) > maxLeafCacheSize {
+		back := ca.lru.Back()
+		if back == nil {
+			break
+		}
+		oldest := back.Value.(string)
+		ca.lru.Remove(back)
+		delete(ca.lruIndex, oldest)
+		delete(ca.leaves, oldest)
+	}
+	return leaf, nil
+}
+
+// mintLeaf signs a fresh leaf certificate for host, valid for leafValidity,
+// using the root CA's key.
+func (ca *CertAuthority) mintLeaf(host string) (*tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	// IP-addressed origins (e.g. httptest.NewTLSServer) are verified against
+	// IPAddresses SANs, never DNSNames, so a literal IP must go there instead.
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("sign leaf certificate for %s: %v", host, err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	}, nil
+}
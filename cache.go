@@ -0,0 +1,469 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxEntrySize and defaultMaxCacheBytes back the --max-entry-size
+// and --max-cache-bytes flags when the caller doesn't override them.
+const (
+	defaultMaxEntrySize  = 8 * 1024 * 1024
+	defaultMaxCacheBytes = 512 * 1024 * 1024
+)
+
+// lruElement is the value stored in Cache.lru, letting us evict by size
+// without re-fetching each entry's body from the Store.
+type lruElement struct {
+	key  string
+	size int
+}
+
+// entrySize approximates the bytes an entry occupies, counting the body
+// and a rough estimate for header overhead.
+func entrySize(entry *CacheEntry) int {
+	size := len(entry.Body)
+	for name, values := range entry.Headers {
+		size += len(name)
+		for _, v := range values {
+			size += len(v)
+		}
+	}
+	return size
+}
+
+// CacheEntry represents a single cached response along with the bits of
+// RFC 7234 state needed to decide freshness and revalidate it later.
+type CacheEntry struct {
+	Body           []byte        `json:"body"`
+	Headers        http.Header   `json:"headers"`
+	StatusCode     int           `json:"status_code"`
+	Timestamp      time.Time     `json:"timestamp"`
+	TTL            time.Duration `json:"ttl"`
+	Vary           []string      `json:"vary"`
+	MustRevalidate bool          `json:"must_revalidate"`
+}
+
+// age reports how long ago the entry was stored or last revalidated.
+func (e *CacheEntry) age() time.Duration {
+	return time.Since(e.Timestamp)
+}
+
+func (e *CacheEntry) isFresh() bool {
+	return e.age() < e.TTL
+}
+
+// requestCacheControl captures the request-side Cache-Control directives
+// that affect how we're allowed to serve from cache (RFC 7234 §5.2.1).
+type requestCacheControl struct {
+	noCache      bool
+	noStore      bool
+	onlyIfCached bool
+	maxAge       time.Duration
+	maxAgeSet    bool
+	minFresh     time.Duration
+	minFreshSet  bool
+	maxStale     time.Duration
+	maxStaleSet  bool
+}
+
+// responseCacheControl captures the response-side directives that decide
+// whether, and for how long, a response may be stored (RFC 7234 §5.2.2).
+type responseCacheControl struct {
+	noStore        bool
+	private        bool
+	public         bool
+	mustRevalidate bool
+	maxAge         time.Duration
+	maxAgeSet      bool
+	sMaxAge        time.Duration
+	sMaxAgeSet     bool
+}
+
+func parseRequestCacheControl(h http.Header) requestCacheControl {
+	var cc requestCacheControl
+	for _, directive := range splitCacheControl(h.Get("Cache-Control")) {
+		name, value := splitDirective(directive)
+		switch name {
+		case "no-cache":
+			cc.noCache = true
+		case "no-store":
+			cc.noStore = true
+		case "only-if-cached":
+			cc.onlyIfCached = true
+		case "max-age":
+			if d, ok := parseDeltaSeconds(value); ok {
+				cc.maxAge, cc.maxAgeSet = d, true
+			}
+		case "min-fresh":
+			if d, ok := parseDeltaSeconds(value); ok {
+				cc.minFresh, cc.minFreshSet = d, true
+			}
+		case "max-stale":
+			// A bare "max-stale" (no value) means "any staleness is acceptable".
+			if value == "" {
+				cc.maxStale, cc.maxStaleSet = time.Duration(1<<63-1), true
+				continue
+			}
+			if d, ok := parseDeltaSeconds(value); ok {
+				cc.maxStale, cc.maxStaleSet = d, true
+			}
+		}
+	}
+	return cc
+}
+
+func parseResponseCacheControl(h http.Header) responseCacheControl {
+	var cc responseCacheControl
+	for _, directive := range splitCacheControl(h.Get("Cache-Control")) {
+		name, value := splitDirective(directive)
+		switch name {
+		case "no-store":
+			cc.noStore = true
+		case "private":
+			cc.private = true
+		case "public":
+			cc.public = true
+		case "must-revalidate":
+			cc.mustRevalidate = true
+		case "max-age":
+			if d, ok := parseDeltaSeconds(value); ok {
+				cc.maxAge, cc.maxAgeSet = d, true
+			}
+		case "s-maxage":
+			if d, ok := parseDeltaSeconds(value); ok {
+				cc.sMaxAge, cc.sMaxAgeSet = d, true
+			}
+		}
+	}
+	return cc
+}
+
+func splitCacheControl(value string) []string {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	directives := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			directives = append(directives, p)
+		}
+	}
+	return directives
+}
+
+func splitDirective(directive string) (name, value string) {
+	name, value, _ = strings.Cut(directive, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	return name, value
+}
+
+func parseDeltaSeconds(value string) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// isCacheable decides whether a response may be stored at all, per the
+// response's Cache-Control directives and the presence of Set-Cookie.
+func isCacheable(statusCode int, h http.Header, cc responseCacheControl) bool {
+	if statusCode < 200 || statusCode >= 400 {
+		return false
+	}
+	if cc.noStore || cc.private {
+		return false
+	}
+	if h.Get("Set-Cookie") != "" && !cc.public {
+		return false
+	}
+	return true
+}
+
+// computeTTL derives a TTL from s-maxage/max-age/Expires, falling back to
+// the heuristic freshness lifetime used by most caches: a fraction of how
+// long the resource has already gone unmodified.
+func computeTTL(h http.Header, cc responseCacheControl, now time.Time) time.Duration {
+	if cc.sMaxAgeSet {
+		return cc.sMaxAge
+	}
+	if cc.maxAgeSet {
+		return cc.maxAge
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			if d := t.Sub(now); d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+	if lastModified := h.Get("Last-Modified"); lastModified != "" {
+		if t, err := http.ParseTime(lastModified); err == nil && now.After(t) {
+			return time.Duration(float64(now.Sub(t)) * 0.1)
+		}
+	}
+	return 5 * time.Minute
+}
+
+// normalizedVaryHeaders returns the sorted, lower-cased header names listed
+// in a Vary response header.
+func normalizedVaryHeaders(h http.Header) []string {
+	raw := h.Values("Vary")
+	if len(raw) == 0 {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	var names []string
+	for _, line := range raw {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varySignature renders the normalized values of the given request headers
+// so they can be folded into a cache key.
+func varySignature(req *http.Request, varyHeaders []string) string {
+	if len(varyHeaders) == 0 {
+		return ""
+	}
+	parts := make([]string, len(varyHeaders))
+	for i, name := range varyHeaders {
+		parts[i] = name + "=" + strings.TrimSpace(req.Header.Get(name))
+	}
+	return strings.Join(parts, "&")
+}
+
+// Cache is the caching layer used by ProxyServer. It delegates the actual
+// storage of entries to a pluggable Store (memory/bbolt/sqlite), and on top
+// of that tracks, per base key (method+URL, independent of Vary), which
+// request headers the origin told us to vary on, so a lookup can compute
+// the right variant key before the real entry is known.
+type Cache struct {
+	store     Store
+	varyIndex map[string][]string
+
+	maxCacheBytes int
+	lru           *list.List
+	lruIndex      map[string]*list.Element
+	totalBytes    int
+	evictions     int
+
+	mutex sync.RWMutex
+}
+
+// NewCache returns a Cache backed by a plain in-memory Store, with the
+// default LRU byte budget. Most tests and call sites that don't care about
+// persistence or eviction tuning use this.
+func NewCache() *Cache {
+	return NewCacheWithStore(newMemoryStore())
+}
+
+// NewCacheWithStore returns a Cache backed by the given Store, as selected
+// by the --store CLI flag, with the default LRU byte budget.
+func NewCacheWithStore(store Store) *Cache {
+	return NewCacheWithOptions(store, defaultMaxCacheBytes)
+}
+
+// NewCacheWithOptions is the full constructor, letting callers tune the
+// --max-cache-bytes budget the LRU evicts against.
+func NewCacheWithOptions(store Store, maxCacheBytes int) *Cache {
+	if maxCacheBytes <= 0 {
+		maxCacheBytes = defaultMaxCacheBytes
+	}
+	c := &Cache{
+		store:         store,
+		varyIndex:     make(map[string][]string),
+		maxCacheBytes: maxCacheBytes,
+		lru:           list.New(),
+		lruIndex:      make(map[string]*list.Element),
+	}
+	c.seedLRU()
+	return c
+}
+
+// seedLRU walks whatever the Store already has on disk (entries written by
+// a previous process against a bbolt/sqlite Store) and folds them into the
+// LRU so the --max-cache-bytes budget accounts for the whole working set
+// from the start, not just entries added after this restart. Anything
+// already over budget at startup is evicted immediately.
+func (c *Cache) seedLRU() {
+	var keys []string
+	c.store.Iterate(func(key string, entry *CacheEntry) bool {
+		keys = append(keys, key)
+
+		c.mutex.Lock()
+		size := entrySize(entry)
+		el := c.lru.PushFront(&lruElement{key: key, size: size})
+		c.lruIndex[key] = el
+		c.totalBytes += size
+		c.mutex.Unlock()
+		return true
+	})
+	if len(keys) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.totalBytes > c.maxCacheBytes {
+		back := c.lru.Back()
+		if back == nil {
+			break
+		}
+		oldest := back.Value.(*lruElement)
+		c.lru.Remove(back)
+		delete(c.lruIndex, oldest.key)
+		c.totalBytes -= oldest.size
+		c.store.Delete(oldest.key)
+		c.evictions++
+	}
+}
+
+func (c *Cache) Get(key string) (*CacheEntry, bool) {
+	entry, hit := c.store.Get(key)
+	if hit {
+		c.mutex.Lock()
+		if el, ok := c.lruIndex[key]; ok {
+			c.lru.MoveToFront(el)
+		}
+		c.mutex.Unlock()
+	}
+	return entry, hit
+}
+
+// Set stores entry under key and, if this push takes the cache over its
+// byte budget, evicts the least-recently-used entries until back under
+// budget. It reports whether an eviction occurred so callers can surface
+// X-Cache-Evicted.
+func (c *Cache) Set(key string, entry *CacheEntry) bool {
+	c.store.Set(key, entry)
+
+	size := entrySize(entry)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.lruIndex[key]; ok {
+		c.totalBytes -= el.Value.(*lruElement).size
+		el.Value = &lruElement{key: key, size: size}
+		c.lru.MoveToFront(el)
+	} else {
+		el := c.lru.PushFront(&lruElement{key: key, size: size})
+		c.lruIndex[key] = el
+	}
+	c.totalBytes += size
+
+	evicted := false
+	for c.totalBytes > c.maxCacheBytes {
+		back := c.lru.Back()
+		if back == nil {
+			break
+		}
+		oldest := back.Value.(*lruElement)
+		if oldest.key == key {
+			// Nothing else left to evict; a single entry exceeds the budget.
+			break
+		}
+		c.lru.Remove(back)
+		delete(c.lruIndex, oldest.key)
+		c.totalBytes -= oldest.size
+		c.store.Delete(oldest.key)
+		c.evictions++
+		evicted = true
+	}
+	return evicted
+}
+
+// Evictions returns the number of entries the LRU has evicted so far.
+func (c *Cache) Evictions() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.evictions
+}
+
+// VaryHeadersFor returns the request header names previously recorded for
+// baseKey, if the origin has ever sent a Vary header for that resource.
+// varyIndex is only a read-through cache of what SetVaryHeadersFor already
+// persisted via c.store: a process that restarts with a bbolt/sqlite store
+// starts with an empty varyIndex but still finds the right answer here, so
+// generateCacheKey folds in the same vary signature the entry was actually
+// stored under before the restart.
+func (c *Cache) VaryHeadersFor(baseKey string) []string {
+	c.mutex.RLock()
+	headers, ok := c.varyIndex[baseKey]
+	c.mutex.RUnlock()
+	if ok {
+		return headers
+	}
+
+	headers, ok = c.store.VaryHeaders(baseKey)
+	if !ok {
+		return nil
+	}
+	c.mutex.Lock()
+	c.varyIndex[baseKey] = headers
+	c.mutex.Unlock()
+	return headers
+}
+
+func (c *Cache) SetVaryHeadersFor(baseKey string, varyHeaders []string) {
+	c.mutex.Lock()
+	if len(varyHeaders) == 0 {
+		delete(c.varyIndex, baseKey)
+	} else {
+		c.varyIndex[baseKey] = varyHeaders
+	}
+	c.mutex.Unlock()
+
+	c.store.SetVaryHeaders(baseKey, varyHeaders)
+}
+
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	c.varyIndex = make(map[string][]string)
+	c.lru = list.New()
+	c.lruIndex = make(map[string]*list.Element)
+	c.totalBytes = 0
+	c.mutex.Unlock()
+
+	c.store.ClearVaryHeaders()
+
+	var keys []string
+	c.store.Iterate(func(key string, _ *CacheEntry) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		c.store.Delete(key)
+	}
+}
+
+func (c *Cache) Size() int {
+	return c.store.Size()
+}
+
+// Close releases the underlying Store's resources (file handles, DB
+// connections, background compaction goroutines).
+func (c *Cache) Close() error {
+	return c.store.Close()
+}
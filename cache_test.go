@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func bodyEntry(body string) *CacheEntry {
+	return &CacheEntry{
+		Body:       []byte(body),
+		Headers:    http.Header{},
+		StatusCode: 200,
+		Timestamp:  time.Now(),
+		TTL:        1 * time.Minute,
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsedOverBudget(t *testing.T) {
+	cache := NewCacheWithOptions(newMemoryStore(), 10)
+
+	cache.Set("a", bodyEntry("1234567890")) // exactly at budget
+	if cache.Evictions() != 0 {
+		t.Fatalf("Expected no eviction yet, got %d", cache.Evictions())
+	}
+
+	cache.Set("b", bodyEntry("1234567890"))
+	if cache.Evictions() != 1 {
+		t.Errorf("Expected one eviction once over budget, got %d", cache.Evictions())
+	}
+	if _, hit := cache.Get("a"); hit {
+		t.Error("Expected the oldest entry to have been evicted")
+	}
+	if _, hit := cache.Get("b"); !hit {
+		t.Error("Expected the newest entry to still be cached")
+	}
+}
+
+func TestCacheGetRefreshesRecency(t *testing.T) {
+	cache := NewCacheWithOptions(newMemoryStore(), 12)
+
+	cache.Set("a", bodyEntry("12345"))
+	cache.Set("b", bodyEntry("12345"))
+
+	// Touch "a" so it becomes most-recently-used, ahead of "b".
+	cache.Get("a")
+
+	cache.Set("c", bodyEntry("12345"))
+
+	if _, hit := cache.Get("b"); hit {
+		t.Error("Expected least-recently-used entry 'b' to be evicted")
+	}
+	if _, hit := cache.Get("a"); !hit {
+		t.Error("Expected recently-touched entry 'a' to survive eviction")
+	}
+}
+
+// TestCacheSeedsLRUFromStoreOnStartup reproduces the bug a restart against
+// a persistent Store would otherwise hit: an entry written before restart
+// must still count against --max-cache-bytes afterward, or the budget only
+// ever applies to entries written since the restart.
+func TestCacheSeedsLRUFromStoreOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.bbolt")
+
+	writer, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("open bbolt store: %v", err)
+	}
+	writer.Set("old", bodyEntry("1234567890")) // 10 bytes
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer store: %v", err)
+	}
+
+	reader, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("reopen bbolt store: %v", err)
+	}
+	defer reader.Close()
+
+	cache := NewCacheWithOptions(reader, 12)
+	if cache.Evictions() != 0 {
+		t.Fatalf("Expected no eviction from seeding alone, got %d", cache.Evictions())
+	}
+	if _, hit := cache.Get("old"); !hit {
+		t.Fatal("Expected the pre-existing entry to be seeded into the cache")
+	}
+
+	cache.Set("new", bodyEntry("12345")) // pushes total over the 12-byte budget
+
+	if cache.Evictions() != 1 {
+		t.Errorf("Expected the pre-existing entry to be evicted once over budget, got %d", cache.Evictions())
+	}
+	if _, hit := cache.Get("old"); hit {
+		t.Error("Expected the restored entry to be evictable, not permanently invisible to the LRU")
+	}
+}
+
+// TestCacheVaryHeadersSurviveRestartOnPersistentStore reproduces the bug a
+// restart would otherwise hit: a second Cache opened against the same bbolt
+// file must recompute the exact same key generateCacheKey folded the Vary
+// signature into the first time, or the entry it stored becomes permanently
+// unreachable.
+func TestCacheVaryHeadersSurviveRestartOnPersistentStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.bbolt")
+
+	store1, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("open bbolt store: %v", err)
+	}
+	cache1 := NewCacheWithStore(store1)
+	cache1.SetVaryHeadersFor("base-key", []string{"accept-encoding"})
+	if err := cache1.Close(); err != nil {
+		t.Fatalf("close first cache: %v", err)
+	}
+
+	store2, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("reopen bbolt store: %v", err)
+	}
+	cache2 := NewCacheWithStore(store2)
+	defer cache2.Close()
+
+	if got := cache2.VaryHeadersFor("base-key"); len(got) != 1 || got[0] != "accept-encoding" {
+		t.Errorf("Expected vary headers to survive a restart against the persistent store, got %v", got)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveReloadPath and liveReloadInvalidatePath are the proxy's own endpoints,
+// exposed only when --live-reload is set. They live under a namespace
+// unlikely to collide with anything a real origin serves.
+const (
+	liveReloadPath           = "/__cache_proxy/reload"
+	liveReloadInvalidatePath = "/__cache_proxy/invalidate"
+	controlSocketName        = "control.sock"
+)
+
+// liveReloadScript is injected before </body> in cache-miss HTML responses
+// when --live-reload is enabled, mirroring air's browser live-reload: it
+// opens an EventSource against liveReloadPath and reloads the page on the
+// "reload" event.
+const liveReloadScript = `<script>(function(){try{new EventSource("` + liveReloadPath + `").addEventListener("reload",function(){location.reload()})}catch(e){}})();</script>`
+
+// LiveReloadBroker fans a "reload" SSE event out to every browser tab
+// holding open liveReloadPath, and owns the unix control socket that lets
+// `clear-cache` (or the TUI's 'i' key) trigger that broadcast on a running
+// instance.
+type LiveReloadBroker struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+
+	controlListener net.Listener
+}
+
+func NewLiveReloadBroker() *LiveReloadBroker {
+	return &LiveReloadBroker{clients: make(map[chan struct{}]struct{})}
+}
+
+// ServeSSE registers the requesting client and streams "event: reload"
+// frames to it until the client disconnects.
+func (b *LiveReloadBroker) ServeSSE(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.clients[ch] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, ch)
+		b.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// Broadcast wakes every connected SSE client so it emits a reload event.
+func (b *LiveReloadBroker) Broadcast() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ListenControlSocket opens the unix socket at ~/.caching-proxy/control.sock
+// that `caching-proxy clear-cache` dials to invalidate a running instance
+// without knowing its HTTP port. Any connection triggers onInvalidate.
+//
+// Since every instance now listens here regardless of --live-reload,
+// callers running several instances at once (e.g. two ports on one host)
+// would otherwise silently steal the socket from one another; dialing it
+// first distinguishes a genuinely stale file from one another instance
+// still owns.
+func (b *LiveReloadBroker) ListenControlSocket(onInvalidate func()) error {
+	path, err := controlSocketPath()
+	if err != nil {
+		return err
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is already in use by another instance", path)
+	}
+	os.Remove(path) // stale socket left behind by an uncleanly-stopped instance
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen on control socket %s: %v", path, err)
+	}
+	b.controlListener = ln
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			onInvalidate()
+			conn.Close()
+		}
+	}()
+	return nil
+}
+
+// Close stops accepting control-socket connections and removes the socket
+// file. SSE clients are left to notice the server going away on their own.
+func (b *LiveReloadBroker) Close() error {
+	if b.controlListener == nil {
+		return nil
+	}
+	path := b.controlListener.Addr().String()
+	err := b.controlListener.Close()
+	os.Remove(path)
+	return err
+}
+
+// controlSocketPath returns ~/.caching-proxy/control.sock, creating the
+// parent directory if necessary.
+func controlSocketPath() (string, error) {
+	dir, err := defaultStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, controlSocketName), nil
+}
+
+// dialControlSocket tries to reach a running instance's control socket and
+// tells it to invalidate its cache and broadcast a live-reload event. It
+// reports whether a running instance was actually reached, so the caller
+// (clear-cache) can fall back to clearing the on-disk store directly.
+func dialControlSocket() bool {
+	path, err := controlSocketPath()
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// injectLiveReloadIfHTML returns body with liveReloadScript injected before
+// </body>, re-deriving it through a gzip decode/re-encode round trip if the
+// response was gzip-encoded so Content-Encoding stays honest. It reports
+// false (and returns body unchanged) for anything that isn't text/html, so
+// callers can skip updating Content-Length.
+func injectLiveReloadIfHTML(body []byte, headers http.Header) ([]byte, bool, error) {
+	if !strings.HasPrefix(headers.Get("Content-Type"), "text/html") {
+		return body, false, nil
+	}
+
+	plain := body
+	gzipped := strings.EqualFold(headers.Get("Content-Encoding"), "gzip")
+	if gzipped {
+		decoded, err := gunzip(body)
+		if err != nil {
+			return body, false, fmt.Errorf("decode gzip body: %v", err)
+		}
+		plain = decoded
+	}
+
+	injected := injectBeforeBodyClose(plain, []byte(liveReloadScript))
+
+	if gzipped {
+		encoded, err := gzipBytes(injected)
+		if err != nil {
+			return body, false, fmt.Errorf("re-encode gzip body: %v", err)
+		}
+		injected = encoded
+	}
+	return injected, true, nil
+}
+
+// injectBeforeBodyClose inserts script immediately before the last </body>
+// (case-insensitively), or appends it to the end of html if no closing tag
+// is found.
+func injectBeforeBodyClose(html, script []byte) []byte {
+	idx := lastIndexCloseBodyTag(html)
+	if idx == -1 {
+		out := make([]byte, 0, len(html)+len(script))
+		return append(append(out, html...), script...)
+	}
+	out := make([]byte, 0, len(html)+len(script))
+	out = append(out, html[:idx]...)
+	out = append(out, script...)
+	out = append(out, html[idx:]...)
+	return out
+}
+
+// lastIndexCloseBodyTag finds the last "</body>" in html, matched
+// case-insensitively over ASCII only. HTML tags are themselves ASCII, and
+// comparing byte-for-byte this way (instead of bytes.ToLower(html), which is
+// Unicode-aware and can change a string's byte length) keeps offsets valid
+// against the original, unmodified buffer.
+func lastIndexCloseBodyTag(html []byte) int {
+	const tag = "</body>"
+	for i := len(html) - len(tag); i >= 0; i-- {
+		if asciiEqualFold(html[i:i+len(tag)], tag) {
+			return i
+		}
+	}
+	return -1
+}
+
+func asciiEqualFold(b []byte, s string) bool {
+	for i := 0; i < len(b); i++ {
+		bc, sc := b[i], s[i]
+		if 'A' <= bc && bc <= 'Z' {
+			bc += 'a' - 'A'
+		}
+		if 'A' <= sc && sc <= 'Z' {
+			sc += 'a' - 'A'
+		}
+		if bc != sc {
+			return false
+		}
+	}
+	return true
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
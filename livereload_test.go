@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInjectLiveReloadIfHTMLSkipsNonHTML(t *testing.T) {
+	headers := http.Header{"Content-Type": []string{"application/json"}}
+	body := []byte(`{"ok":true}`)
+
+	got, injected, err := injectLiveReloadIfHTML(body, headers)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if injected {
+		t.Error("Expected non-HTML content-type to be left uninjected")
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("Expected body unchanged, got %q", got)
+	}
+}
+
+func TestInjectLiveReloadIfHTMLInsertsBeforeBodyClose(t *testing.T) {
+	headers := http.Header{"Content-Type": []string{"text/html; charset=utf-8"}}
+	body := []byte("<html><body><h1>hi</h1></body></html>")
+
+	got, injected, err := injectLiveReloadIfHTML(body, headers)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !injected {
+		t.Fatal("Expected text/html to be injected")
+	}
+	if !bytes.Contains(got, []byte(liveReloadScript)) {
+		t.Error("Expected the live-reload script to be present in the injected body")
+	}
+	if idx := bytes.Index(got, []byte(liveReloadScript)); idx == -1 || idx > bytes.Index(got, []byte("</body>")) {
+		t.Error("Expected the script to appear before </body>")
+	}
+}
+
+func TestInjectLiveReloadIfHTMLRoundTripsGzip(t *testing.T) {
+	headers := http.Header{
+		"Content-Type":     []string{"text/html"},
+		"Content-Encoding": []string{"gzip"},
+	}
+	original := []byte("<html><body>hello</body></html>")
+	gzipped, err := gzipBytes(original)
+	if err != nil {
+		t.Fatalf("Expected no error gzipping fixture, got %v", err)
+	}
+
+	got, injected, err := injectLiveReloadIfHTML(gzipped, headers)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !injected {
+		t.Fatal("Expected gzip-encoded text/html to be injected")
+	}
+
+	decoded, err := gunzip(got)
+	if err != nil {
+		t.Fatalf("Expected the injected body to still be valid gzip, got %v", err)
+	}
+	if !bytes.Contains(decoded, []byte(liveReloadScript)) {
+		t.Error("Expected the decoded body to contain the live-reload script")
+	}
+}
+
+func TestResponseRecorderAbandonsBufferingPastMaxEntrySize(t *testing.T) {
+	w := httptest.NewRecorder()
+	rr := &responseRecorder{
+		ResponseWriter: w,
+		headers:        http.Header{"Content-Type": []string{"text/html"}},
+		body:           make([]byte, 0),
+		maxEntrySize:   8,
+		liveReload:     NewLiveReloadBroker(),
+	}
+
+	rr.WriteHeader(http.StatusOK)
+	if !rr.buffering {
+		t.Fatal("Expected WriteHeader to start buffering an HTML response")
+	}
+
+	rr.Write([]byte("0123456789")) // exceeds maxEntrySize of 8
+
+	if rr.buffering {
+		t.Error("Expected Write to abandon buffering once maxEntrySize is exceeded")
+	}
+	if !rr.tooLarge {
+		t.Error("Expected the oversized response to be marked tooLarge")
+	}
+	if rr.body != nil {
+		t.Error("Expected body to be cleared once buffering is abandoned")
+	}
+	if got := w.Body.String(); got != "0123456789" {
+		t.Errorf("Expected the full body to still reach the client uninjected, got %q", got)
+	}
+}
+
+// TestListenControlSocketRejectsSecondInstance reproduces the collision two
+// instances running at once (e.g. two ports on one host) would otherwise
+// hit: since every instance now listens on the same ~/.caching-proxy/
+// control.sock regardless of --live-reload, a second ListenControlSocket
+// must not silently steal the socket out from under the first.
+func TestListenControlSocketRejectsSecondInstance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	first := NewLiveReloadBroker()
+	if err := first.ListenControlSocket(func() {}); err != nil {
+		t.Fatalf("Expected the first instance to claim the control socket, got %v", err)
+	}
+	defer first.Close()
+
+	second := NewLiveReloadBroker()
+	if err := second.ListenControlSocket(func() {}); err == nil {
+		t.Error("Expected a second instance to be rejected while the first still owns the socket")
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Expected no error closing the first instance, got %v", err)
+	}
+
+	third := NewLiveReloadBroker()
+	if err := third.ListenControlSocket(func() {}); err != nil {
+		t.Errorf("Expected a new instance to claim the socket once the first released it, got %v", err)
+	}
+	defer third.Close()
+}
+
+func TestLiveReloadBrokerBroadcastWakesClients(t *testing.T) {
+	broker := NewLiveReloadBroker()
+
+	ch := make(chan struct{}, 1)
+	broker.mu.Lock()
+	broker.clients[ch] = struct{}{}
+	broker.mu.Unlock()
+
+	broker.Broadcast()
+
+	select {
+	case <-ch:
+	default:
+		t.Error("Expected Broadcast to wake the registered client")
+	}
+}
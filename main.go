@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -20,60 +24,6 @@ import (
 	"github.com/spf13/cobra"
 )
 
-type CacheEntry struct {
-	Body       []byte        `json:"body"`
-	Headers    http.Header   `json:"headers"`
-	StatusCode int           `json:"status_code"`
-	Timestamp  time.Time     `json:"timestamp"`
-	TTL        time.Duration `json:"ttl"`
-}
-
-type Cache struct {
-	entries map[string]*CacheEntry
-	mutex   sync.RWMutex
-}
-
-func NewCache() *Cache {
-	return &Cache{
-		entries: make(map[string]*CacheEntry),
-	}
-}
-
-func (c *Cache) Get(key string) (*CacheEntry, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-
-	entry, exists := c.entries[key]
-	if !exists {
-		return nil, false
-	}
-
-	if time.Since(entry.Timestamp) > entry.TTL {
-		delete(c.entries, key)
-		return nil, false
-	}
-
-	return entry, true
-}
-
-func (c *Cache) Set(key string, entry *CacheEntry) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.entries[key] = entry
-}
-
-func (c *Cache) Clear() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.entries = make(map[string]*CacheEntry)
-}
-
-func (c *Cache) Size() int {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	return len(c.entries)
-}
-
 type RequestLog struct {
 	Method       string
 	Path         string
@@ -84,22 +34,22 @@ type RequestLog struct {
 }
 
 type model struct {
-	server    *ProxyServer
-	port      int
-	origin    string
-	status    string
-	cacheSize int
-	requests  []RequestLog
-	selected  int
-	quitting  bool
-	width     int
-	height    int
-}
-
-func NewModel(port int, origin string) *model {
+	server       *ProxyServer
+	opts         ProxyServerOptions
+	status       string
+	cacheSize    int
+	cacheEvicted int
+	upstreams    []UpstreamStatus
+	requests     []RequestLog
+	selected     int
+	quitting     bool
+	width        int
+	height       int
+}
+
+func NewModel(opts ProxyServerOptions) *model {
 	return &model{
-		port:     port,
-		origin:   origin,
+		opts:     opts,
 		status:   "Starting...",
 		requests: make([]RequestLog, 0),
 		selected: 0,
@@ -111,7 +61,7 @@ func NewModel(port int, origin string) *model {
 func (m *model) Init() tea.Cmd {
 	return tea.Batch(
 		tea.EnterAltScreen,
-		startServer(m.port, m.origin),
+		startServer(m.opts),
 	)
 }
 
@@ -134,6 +84,13 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.server != nil {
 				m.server.cache.Clear()
 				m.cacheSize = 0
+				m.cacheEvicted = 0
+			}
+		case "i":
+			if m.server != nil {
+				m.server.invalidate()
+				m.cacheSize = 0
+				m.cacheEvicted = 0
 			}
 		case "r":
 			return m, refreshData()
@@ -159,6 +116,11 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.selected = len(m.requests) - 1
 		}
 	case tickMsg:
+		if m.server != nil {
+			m.cacheSize = m.server.cache.Size()
+			m.cacheEvicted = m.server.cache.Evictions()
+			m.upstreams = m.server.pool.Snapshot()
+		}
 		return m, tea.Tick(time.Second, func(t time.Time) tea.Msg {
 			return tickMsg(t)
 		})
@@ -166,6 +128,26 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// originSummary renders the configured origin(s) for the TUI header.
+func (m *model) originSummary() string {
+	if len(m.opts.Origins) > 0 {
+		return strings.Join(m.opts.Origins, ", ")
+	}
+	return m.opts.Origin
+}
+
+// upstreamProxySummary renders the configured upstream proxy (and its
+// no-proxy bypass list, if any) for the TUI header, or "none" if unset.
+func (m *model) upstreamProxySummary() string {
+	if m.opts.UpstreamProxy == "" {
+		return "none"
+	}
+	if m.opts.UpstreamProxyNoProxy == "" {
+		return m.opts.UpstreamProxy
+	}
+	return fmt.Sprintf("%s (noproxy: %s)", m.opts.UpstreamProxy, m.opts.UpstreamProxyNoProxy)
+}
+
 func (m *model) View() string {
 	if m.quitting {
 		return "\n  See you later! 👋\n\n"
@@ -194,15 +176,37 @@ func (m *model) View() string {
 	infoStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#626262"))
 
-	s.WriteString(infoStyle.Render(fmt.Sprintf("Port: %d | Origin: %s | Cache Size: %d entries", m.port, m.origin, m.cacheSize)))
+	s.WriteString(infoStyle.Render(fmt.Sprintf("Port: %d | Origin: %s | Cache Size: %d entries | Evicted: %d", m.opts.Port, m.originSummary(), m.cacheSize, m.cacheEvicted)))
+	s.WriteString("\n")
+	s.WriteString(infoStyle.Render(fmt.Sprintf("Upstream Proxy: %s", m.upstreamProxySummary())))
 	s.WriteString("\n\n")
 
+	if len(m.upstreams) > 0 {
+		s.WriteString(lipgloss.NewStyle().Bold(true).Render("Upstreams:"))
+		s.WriteString("\n")
+		for _, up := range m.upstreams {
+			healthLabel := "HEALTHY"
+			healthColor := lipgloss.Color("#04B575")
+			if !up.Healthy {
+				healthLabel = "UNHEALTHY"
+				healthColor = lipgloss.Color("#FF6B6B")
+			}
+			line := fmt.Sprintf("%s  [%s]  in-flight=%d", up.URL, healthLabel, up.InFlight)
+			if up.LastError != "" {
+				line += fmt.Sprintf("  last-error=%s", up.LastError)
+			}
+			s.WriteString(lipgloss.NewStyle().Foreground(healthColor).Render(line))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n")
+	}
+
 	controlsStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#626262")).
 		Italic(true)
 
 	s.WriteString(controlsStyle.Render("Controls: "))
-	s.WriteString("↑/↓ Navigate | c Clear Cache | r Refresh | q Quit")
+	s.WriteString("↑/↓ Navigate | c Clear Cache | i Invalidate+Reload | r Refresh | q Quit")
 	s.WriteString("\n\n")
 
 	if len(m.requests) > 0 {
@@ -296,9 +300,9 @@ type requestLogMsg struct {
 
 type tickMsg time.Time
 
-func startServer(port int, origin string) tea.Cmd {
+func startServer(opts ProxyServerOptions) tea.Cmd {
 	return func() tea.Msg {
-		proxy, err := NewProxyServer(origin, port)
+		proxy, err := NewProxyServerWithOptions(opts)
 		if err != nil {
 			return serverErrorMsg{error: err.Error()}
 		}
@@ -328,27 +332,137 @@ func tick() tea.Cmd {
 }
 
 type ProxyServer struct {
-	origin *url.URL
-	cache  *Cache
-	port   int
-	mu     sync.RWMutex
+	pool         *UpstreamPool
+	cache        *Cache
+	ca           *CertAuthority
+	transport    *http.Transport
+	port         int
+	maxEntrySize int
+	liveReload   *LiveReloadBroker // nil unless --live-reload is set; gates HTML injection and the SSE endpoint
+	controlSock  *LiveReloadBroker // always set; owns the control socket `clear-cache` dials to invalidate a running instance, live-reload or not
+	mu           sync.RWMutex
+}
+
+// ProxyServerOptions groups the growing set of knobs NewProxyServer takes,
+// so adding a new one (store backend, load-balancing policy, ...) doesn't
+// mean widening a positional argument list.
+type ProxyServerOptions struct {
+	Origin               string   // a single origin; ignored if Origins is non-empty
+	Origins              []string // one or more origins to load-balance across
+	Port                 int
+	Store                Store // if nil, built from StoreKind/StorePath (in-memory when StoreKind is empty)
+	StoreKind            string
+	StorePath            string
+	MaxEntrySize         int // 0 falls back to defaultMaxEntrySize
+	MaxCacheBytes        int // 0 falls back to defaultMaxCacheBytes
+	LBPolicy             string
+	HealthPath           string
+	HealthInterval       time.Duration
+	UnhealthyThreshold   int
+	UnhealthyWindow      time.Duration
+	MaxRetries           int
+	CACertPath           string // defaults under ~/.caching-proxy/ca.crt, generated if absent
+	CAKeyPath            string // defaults under ~/.caching-proxy/ca.key, generated if absent
+	UpstreamProxy        string // http(s):// or socks5:// proxy to forward all origin requests through
+	UpstreamProxyNoProxy string // comma-separated host globs that bypass UpstreamProxy
+	LiveReload           bool   // inject a live-reload <script> into cache-miss text/html responses
+
+	// InsecureSkipVerifyUpstream skips certificate verification when the
+	// proxy re-dials an origin after terminating a client's intercepted
+	// HTTPS connection (see handleConnect). Origins presenting a
+	// self-signed or privately-issued certificate -- which ps.ca's own
+	// interception otherwise has no way to establish trust for -- need
+	// this set, or every re-dial fails with "certificate signed by
+	// unknown authority".
+	InsecureSkipVerifyUpstream bool
 }
 
 func NewProxyServer(originURL string, port int) (*ProxyServer, error) {
-	origin, err := url.Parse(originURL)
+	return NewProxyServerWithOptions(ProxyServerOptions{Origin: originURL, Port: port})
+}
+
+// NewProxyServerWithOptions is the full constructor; NewProxyServer remains
+// for callers (and tests) that only care about the origin and port.
+func NewProxyServerWithOptions(opts ProxyServerOptions) (*ProxyServer, error) {
+	origins := opts.Origins
+	if len(origins) == 0 {
+		origins = []string{opts.Origin}
+	}
+
+	pool, err := NewUpstreamPool(origins, UpstreamPoolOptions{
+		Policy:             opts.LBPolicy,
+		HealthPath:         opts.HealthPath,
+		HealthInterval:     opts.HealthInterval,
+		UnhealthyThreshold: opts.UnhealthyThreshold,
+		UnhealthyWindow:    opts.UnhealthyWindow,
+		MaxRetries:         opts.MaxRetries,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("invalid origin URL: %v", err)
+		return nil, err
+	}
+
+	store := opts.Store
+	if store == nil {
+		store, err = NewStore(opts.StoreKind, opts.StorePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxEntrySize := opts.MaxEntrySize
+	if maxEntrySize <= 0 {
+		maxEntrySize = defaultMaxEntrySize
+	}
+
+	ca, err := LoadOrCreateCertAuthority(opts.CACertPath, opts.CAKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := NewUpstreamTransport(opts.UpstreamProxy, splitNoProxyList(opts.UpstreamProxyNoProxy), opts.InsecureSkipVerifyUpstream)
+	if err != nil {
+		return nil, err
+	}
+
+	var liveReload *LiveReloadBroker
+	if opts.LiveReload {
+		liveReload = NewLiveReloadBroker()
+	}
+
+	// clear-cache needs a running instance's control socket regardless of
+	// whether --live-reload is on; reuse liveReload's broker (and so its
+	// SSE clients) when it exists instead of standing up a second one.
+	controlSock := liveReload
+	if controlSock == nil {
+		controlSock = NewLiveReloadBroker()
 	}
 
 	return &ProxyServer{
-		origin: origin,
-		cache:  NewCache(),
-		port:   port,
+		pool:         pool,
+		cache:        NewCacheWithOptions(store, opts.MaxCacheBytes),
+		ca:           ca,
+		transport:    transport,
+		port:         opts.Port,
+		maxEntrySize: maxEntrySize,
+		liveReload:   liveReload,
+		controlSock:  controlSock,
 	}, nil
 }
 
-func (ps *ProxyServer) generateCacheKey(req *http.Request) string {
-	data := fmt.Sprintf("%s:%s:%s", req.Method, req.URL.String(), req.Header.Get("User-Agent"))
+// baseCacheKey identifies a resource independent of any Vary-selected
+// request headers, so we can look up which headers it varies on before
+// computing the real cache key.
+func (ps *ProxyServer) baseCacheKey(req *http.Request) string {
+	data := fmt.Sprintf("%s:%s", req.Method, req.URL.String())
+	hash := md5.Sum([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
+// generateCacheKey folds the normalized values of varyHeaders (as recorded
+// from a prior response's Vary header) into the base key, so distinct
+// variants of the same URL get distinct entries.
+func (ps *ProxyServer) generateCacheKey(req *http.Request, varyHeaders []string) string {
+	data := fmt.Sprintf("%s:%s:%s", req.Method, req.URL.String(), varySignature(req, varyHeaders))
 	hash := md5.Sum([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
@@ -356,55 +470,359 @@ func (ps *ProxyServer) generateCacheKey(req *http.Request) string {
 func (ps *ProxyServer) handleRequest(w http.ResponseWriter, req *http.Request) {
 	start := time.Now()
 
-	cacheKey := ps.generateCacheKey(req)
+	reqCC := parseRequestCacheControl(req.Header)
+	baseKey := ps.baseCacheKey(req)
+
+	if !reqCC.noStore {
+		varyHeaders := ps.cache.VaryHeadersFor(baseKey)
+		cacheKey := ps.generateCacheKey(req, varyHeaders)
+
+		if cachedEntry, hit := ps.cache.Get(cacheKey); hit {
+			if ps.servableFromCache(cachedEntry, reqCC) {
+				ps.writeCachedResponse(w, cachedEntry, "HIT")
+				log.Printf("Cache HIT for %s", req.URL.Path)
+				ps.logRequest(req, strconv.Itoa(cachedEntry.StatusCode), true, time.Since(start))
+				return
+			}
 
-	if cachedEntry, hit := ps.cache.Get(cacheKey); hit {
-		for key, values := range cachedEntry.Headers {
-			for _, value := range values {
-				w.Header().Add(key, value)
+			if refreshed, revalidated := ps.revalidate(cachedEntry, cacheKey, req); revalidated {
+				ps.writeCachedResponse(w, refreshed, "REVALIDATED")
+				log.Printf("Cache REVALIDATED for %s", req.URL.Path)
+				ps.logRequest(req, strconv.Itoa(refreshed.StatusCode), true, time.Since(start))
+				return
 			}
+
+			if reqCC.onlyIfCached {
+				http.Error(w, "key not available in cache", http.StatusGatewayTimeout)
+				ps.logRequest(req, strconv.Itoa(http.StatusGatewayTimeout), false, time.Since(start))
+				return
+			}
+		} else if reqCC.onlyIfCached {
+			http.Error(w, "key not available in cache", http.StatusGatewayTimeout)
+			ps.logRequest(req, strconv.Itoa(http.StatusGatewayTimeout), false, time.Since(start))
+			return
 		}
-		w.Header().Set("X-Cache", "HIT")
-		w.WriteHeader(cachedEntry.StatusCode)
-		w.Write(cachedEntry.Body)
-		log.Printf("Cache HIT for %s", req.URL.Path)
+	}
 
-		ps.logRequest(req, "200", true, time.Since(start))
+	log.Printf("Cache MISS for %s", req.URL.Path)
+
+	recorder, err := ps.forward(w, req)
+	if err != nil {
+		http.Error(w, "no healthy upstream available", http.StatusBadGateway)
+		ps.logRequest(req, strconv.Itoa(http.StatusBadGateway), false, time.Since(start))
 		return
 	}
 
-	log.Printf("Cache MISS for %s", req.URL.Path)
+	respCC := parseResponseCacheControl(recorder.headers)
+
+	// recorder withholds the status line and body (see responseRecorder.
+	// WriteHeader) until flushBuffered/abandonBuffering sends them, so these
+	// diagnostic headers land on recorder.headers here and reach the client
+	// when the response is actually written below - not on w, which by now
+	// may already have been flushed straight through if the response turned
+	// out to be too large to buffer.
+	if !recorder.tooLarge {
+		if !reqCC.noStore && isCacheable(recorder.statusCode, recorder.headers, respCC) {
+			now := time.Now()
+			varyHeaders := normalizedVaryHeaders(recorder.headers)
+			// Clone before handing headers to the cache: for the memory Store,
+			// Cache.Set keeps this exact map by reference, and the diagnostic
+			// X-Cache/X-Cache-Evicted headers set on recorder.headers below
+			// would otherwise land permanently in the stored entry and leak
+			// into every later HIT for this key.
+			cacheEntry := &CacheEntry{
+				Body:           recorder.body,
+				Headers:        recorder.headers.Clone(),
+				StatusCode:     recorder.statusCode,
+				Timestamp:      now,
+				TTL:            computeTTL(recorder.headers, respCC, now),
+				Vary:           varyHeaders,
+				MustRevalidate: respCC.mustRevalidate,
+			}
+			ps.cache.SetVaryHeadersFor(baseKey, varyHeaders)
+			if ps.cache.Set(ps.generateCacheKey(req, varyHeaders), cacheEntry) {
+				recorder.headers.Set("X-Cache-Evicted", "true")
+			}
+			log.Printf("Cached response for %s", req.URL.Path)
+		}
+		recorder.headers.Set("X-Cache", "MISS")
+		ps.flushBuffered(recorder)
+	}
+	// When recorder.tooLarge, abandonBuffering already set X-Cache and
+	// X-Cache-Too-Large and flushed the response straight through.
+
+	ps.logRequest(req, strconv.Itoa(recorder.statusCode), false, time.Since(start))
+}
+
+// forward selects a healthy upstream and proxies req to it. A connection
+// failure (the upstream never responded at all) or a 5xx response is
+// retried against the next healthy upstream up to pool.MaxRetries, with
+// exponential backoff; both are recorded as a passive failure either way.
+// A 5xx response is only retried while recorder.tooLarge is false: once the
+// body overflows maxEntrySize, abandonBuffering has already streamed the
+// status line and partial body straight to the client, so nothing can be
+// retried without double-sending - that response is returned as-is. If
+// retries are exhausted or no further healthy upstream remains, the last
+// 5xx response seen is returned rather than discarded.
+//
+// req.Body is drained by the first attempt's round trip, so a request with
+// a body is buffered into memory up front and replayed fresh on every
+// attempt - req.Clone (used by forwardToUpstream) only copies the Body
+// pointer, not its contents, so reusing req.Body as-is would send a retry
+// with nothing left to read.
+func (ps *ProxyServer) forward(w http.ResponseWriter, req *http.Request) (*responseRecorder, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		bodyBytes, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %v", err)
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(bodyBytes)), nil
+		}
+	}
 
-	ps.logRequest(req, "200", false, time.Since(start))
+	excluded := make(map[string]bool)
+	var lastRecorder *responseRecorder
 
-	proxy := httputil.NewSingleHostReverseProxy(ps.origin)
+	for attempt := 0; attempt <= ps.pool.MaxRetries(); attempt++ {
+		upstream := ps.pool.Select(req, excluded)
+		if upstream == nil {
+			if lastRecorder != nil {
+				return lastRecorder, nil
+			}
+			return nil, fmt.Errorf("no healthy upstream available")
+		}
 
-	req.URL.Host = ps.origin.Host
-	req.URL.Scheme = ps.origin.Scheme
-	req.Host = ps.origin.Host
+		if attempt > 0 {
+			time.Sleep(backoffDuration(attempt))
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewind request body: %v", err)
+			}
+			req.Body = body
+		}
+
+		upstream.InFlight.Add(1)
+		recorder, connErr := ps.forwardToUpstream(w, req, upstream)
+		upstream.InFlight.Add(-1)
+
+		if connErr != nil {
+			ps.pool.RecordFailure(upstream, connErr)
+			excluded[upstream.URL.Host] = true
+			continue
+		}
+
+		if recorder.statusCode >= 500 {
+			ps.pool.RecordFailure(upstream, fmt.Errorf("upstream returned %d", recorder.statusCode))
+			// recorder withholds the status line and body until handleRequest
+			// flushes it (see responseRecorder.WriteHeader), unless the body
+			// already overflowed maxEntrySize and abandonBuffering streamed it
+			// straight through - in that case the client already has bytes on
+			// the wire and retrying would double-send, so only 5xx responses
+			// still under maxEntrySize are eligible for a retry.
+			if !recorder.tooLarge {
+				lastRecorder = recorder
+				excluded[upstream.URL.Host] = true
+				continue
+			}
+		} else {
+			ps.pool.RecordSuccess(upstream)
+		}
+		return recorder, nil
+	}
+
+	if lastRecorder != nil {
+		return lastRecorder, nil
+	}
+	return nil, fmt.Errorf("exhausted retries against %d upstream(s)", len(ps.pool.upstreams))
+}
+
+// forwardToUpstream proxies req to a single upstream and records the
+// response into a responseRecorder. The returned error is non-nil only for
+// transport-level failures (the upstream never responded at all).
+func (ps *ProxyServer) forwardToUpstream(w http.ResponseWriter, req *http.Request, upstream *Upstream) (*responseRecorder, error) {
+	proxy := httputil.NewSingleHostReverseProxy(upstream.URL)
+	proxy.Transport = ps.transport
+
+	var connErr error
+	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
+		connErr = err
+	}
+
+	upstreamReq := req.Clone(req.Context())
+	upstreamReq.URL.Host = upstream.URL.Host
+	upstreamReq.URL.Scheme = upstream.URL.Scheme
+	upstreamReq.Host = upstream.URL.Host
 
 	recorder := &responseRecorder{
 		ResponseWriter: w,
 		statusCode:     200,
 		headers:        make(http.Header),
 		body:           make([]byte, 0),
+		maxEntrySize:   ps.maxEntrySize,
+		liveReload:     ps.liveReload,
 	}
 
-	proxy.ServeHTTP(recorder, req)
+	proxy.ServeHTTP(recorder, upstreamReq)
+	if connErr != nil {
+		return nil, connErr
+	}
+	// recorder withholds the status line and body until handleRequest (or,
+	// if the response overflowed maxEntrySize, responseRecorder itself via
+	// abandonBuffering) sends them - see responseRecorder.WriteHeader.
+	return recorder, nil
+}
 
-	if recorder.statusCode >= 200 && recorder.statusCode < 400 {
-		cacheEntry := &CacheEntry{
-			Body:       recorder.body,
-			Headers:    recorder.headers,
-			StatusCode: recorder.statusCode,
-			Timestamp:  time.Now(),
-			TTL:        5 * time.Minute,
+// flushBuffered sends a fully-buffered response out to the real client:
+// handleRequest calls this once it has set X-Cache/X-Cache-Evicted on
+// rr.headers, after deciding whether the response is cacheable, so those
+// headers are the very first thing written to the client rather than
+// appended to an already-flushed response. For text/html responses it also
+// injects the live-reload script when --live-reload is on. rr.body itself is
+// left as the pre-injection bytes, since that's what the caching path in
+// handleRequest stores; writeCachedResponse injects again on serve, so a
+// cached entry never poisons the response if --live-reload is later turned
+// off.
+func (ps *ProxyServer) flushBuffered(rr *responseRecorder) {
+	body := rr.body
+	if ps.liveReload != nil {
+		if injected, ok, err := injectLiveReloadIfHTML(rr.body, rr.headers); err != nil {
+			log.Printf("live-reload: inject script: %v", err)
+		} else if ok {
+			body = injected
 		}
-		ps.cache.Set(cacheKey, cacheEntry)
-		log.Printf("Cached response for %s", req.URL.Path)
 	}
 
-	w.Header().Set("X-Cache", "MISS")
+	for key, values := range rr.headers {
+		for _, value := range values {
+			rr.ResponseWriter.Header().Add(key, value)
+		}
+	}
+	rr.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rr.ResponseWriter.WriteHeader(rr.statusCode)
+	rr.ResponseWriter.Write(body)
+}
+
+// servableFromCache applies the request's Cache-Control overrides
+// (no-cache forces revalidation, max-age/min-fresh/max-stale adjust what
+// counts as "fresh enough") on top of the entry's own freshness lifetime.
+// An origin that sent must-revalidate forces revalidation once stale,
+// overriding the client's max-stale (RFC 7234 §5.2.2.1).
+func (ps *ProxyServer) servableFromCache(entry *CacheEntry, reqCC requestCacheControl) bool {
+	if reqCC.noCache {
+		return false
+	}
+
+	age := entry.age()
+	ttl := entry.TTL
+
+	if reqCC.maxAgeSet && age > reqCC.maxAge {
+		return false
+	}
+	if reqCC.minFreshSet && entry.TTL-age < reqCC.minFresh {
+		return false
+	}
+	if age < ttl {
+		return true
+	}
+	if entry.MustRevalidate {
+		return false
+	}
+	if reqCC.maxStaleSet && age-ttl <= reqCC.maxStale {
+		return true
+	}
+	return false
+}
+
+// revalidate issues a conditional request to the origin using the entry's
+// stored ETag/Last-Modified. On a 304 it stores a fresh copy of the entry
+// (updated headers/timestamp/TTL) under cacheKey via Cache.Set and returns
+// it; otherwise it returns (nil, false) so the caller falls through to a
+// normal cache-miss fetch. entry itself is never mutated: it's the same
+// pointer Cache.Get handed back, still aliased with whatever the store has
+// in its map, and other requests may be reading it concurrently.
+func (ps *ProxyServer) revalidate(entry *CacheEntry, cacheKey string, original *http.Request) (*CacheEntry, bool) {
+	etag := entry.Headers.Get("ETag")
+	lastModified := entry.Headers.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil, false
+	}
+
+	upstream := ps.pool.Select(original, nil)
+	if upstream == nil {
+		return nil, false
+	}
+
+	revalReq := original.Clone(original.Context())
+	revalReq.URL.Host = upstream.URL.Host
+	revalReq.URL.Scheme = upstream.URL.Scheme
+	revalReq.Host = upstream.URL.Host
+	revalReq.Body = nil
+	revalReq.ContentLength = 0
+	if etag != "" {
+		revalReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		revalReq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := ps.transport.RoundTrip(revalReq)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotModified {
+		return nil, false
+	}
+
+	refreshed := &CacheEntry{
+		Body:       entry.Body,
+		Headers:    entry.Headers.Clone(),
+		StatusCode: entry.StatusCode,
+		Vary:       entry.Vary,
+	}
+	for key, values := range resp.Header {
+		refreshed.Headers[key] = values
+	}
+	refreshed.Timestamp = time.Now()
+	respCC := parseResponseCacheControl(refreshed.Headers)
+	refreshed.TTL = computeTTL(refreshed.Headers, respCC, refreshed.Timestamp)
+	refreshed.MustRevalidate = respCC.mustRevalidate
+
+	ps.cache.Set(cacheKey, refreshed)
+	return refreshed, true
+}
+
+func (ps *ProxyServer) writeCachedResponse(w http.ResponseWriter, entry *CacheEntry, cacheStatus string) {
+	for key, values := range entry.Headers {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Age", strconv.Itoa(int(entry.age().Seconds())))
+	w.Header().Set("X-Cache", cacheStatus)
+
+	// Entries are stored pre-injection (see flushBuffered), so the
+	// live-reload script is injected fresh on every serve; toggling
+	// --live-reload off never leaves a poisoned, already-injected entry
+	// behind.
+	body := entry.Body
+	if ps.liveReload != nil {
+		if injected, ok, err := injectLiveReloadIfHTML(body, entry.Headers); err != nil {
+			log.Printf("live-reload: inject script for cached response: %v", err)
+		} else if ok {
+			body = injected
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+
+	w.WriteHeader(entry.StatusCode)
+	w.Write(body)
 }
 
 func (ps *ProxyServer) logRequest(req *http.Request, status string, cacheHit bool, responseTime time.Duration) {
@@ -419,39 +837,230 @@ func (ps *ProxyServer) logRequest(req *http.Request, status string, cacheHit boo
 
 type responseRecorder struct {
 	http.ResponseWriter
-	statusCode int
-	headers    http.Header
-	body       []byte
+	statusCode   int
+	headers      http.Header
+	body         []byte
+	maxEntrySize int  // 0 means unlimited
+	tooLarge     bool // set by abandonBuffering once body exceeds maxEntrySize; status+headers already sent, body now streams through uncached
+
+	liveReload *LiveReloadBroker // non-nil enables HTML injection when flushBuffered sends the response
+	buffering  bool              // true from WriteHeader until flushBuffered or abandonBuffering sends the response
 }
 
+// WriteHeader only records statusCode; it does not touch the real
+// ResponseWriter. The status line and headers aren't sent until the whole
+// body is known - via flushBuffered if it fits within maxEntrySize, or
+// abandonBuffering (called from Write) if it doesn't - so that:
+//   - handleRequest can set X-Cache/X-Cache-Evicted/X-Cache-Too-Large before
+//     anything reaches the client, instead of mutating headers on an
+//     already-flushed response;
+//   - flushBuffered can inject the live-reload script into a complete HTML
+//     body when --live-reload is on.
+//
+// This also fixes headers never reaching the client at all on a cache miss:
+// httputil.ReverseProxy copies the upstream response into rr.Header()'s
+// private map, which previously was never copied onto the real
+// ResponseWriter's headers.
 func (rr *responseRecorder) WriteHeader(statusCode int) {
 	rr.statusCode = statusCode
-	rr.ResponseWriter.WriteHeader(statusCode)
+	rr.buffering = true
 }
 
+// Write buffers into rr.body until it would exceed maxEntrySize, at which
+// point abandonBuffering flushes the deferred status line and everything
+// buffered so far, and the rest of the response streams straight through
+// uncached - mirroring the io.LimitedReader + "too-large" signal pattern
+// used by httpcache, just deferred to keep the status line held back until
+// we know it fits.
 func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if !rr.buffering {
+		return rr.ResponseWriter.Write(data)
+	}
+	if rr.maxEntrySize > 0 && len(rr.body)+len(data) > rr.maxEntrySize {
+		buffered := rr.abandonBuffering()
+		if len(buffered) > 0 {
+			if _, err := rr.ResponseWriter.Write(buffered); err != nil {
+				return 0, err
+			}
+		}
+		return rr.ResponseWriter.Write(data)
+	}
 	rr.body = append(rr.body, data...)
-	return rr.ResponseWriter.Write(data)
+	return len(data), nil
+}
+
+// abandonBuffering flushes the status line the response is too large to
+// hold for (so it can never be injected or cached), marks it tooLarge, and
+// returns whatever had been buffered so far for the caller to write through.
+func (rr *responseRecorder) abandonBuffering() []byte {
+	rr.buffering = false
+	rr.tooLarge = true
+	rr.headers.Set("X-Cache", "MISS")
+	rr.headers.Set("X-Cache-Too-Large", "true")
+	for key, values := range rr.headers {
+		for _, value := range values {
+			rr.ResponseWriter.Header().Add(key, value)
+		}
+	}
+	rr.ResponseWriter.WriteHeader(rr.statusCode)
+	buffered := rr.body
+	rr.body = nil
+	return buffered
 }
 
 func (rr *responseRecorder) Header() http.Header {
 	return rr.headers
 }
 
+// serveHTTP dispatches CONNECT requests (HTTPS tunnels) to handleConnect and
+// everything else to the normal caching path, except that with --live-reload
+// on, the reload and invalidate endpoints are served first. Routing this ourselves rather
+// than through http.HandleFunc/DefaultServeMux avoids ServeMux's special,
+// host-only matching rules for CONNECT requests.
+func (ps *ProxyServer) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if ps.liveReload != nil {
+		switch req.URL.Path {
+		case liveReloadPath:
+			ps.liveReload.ServeSSE(w, req)
+			return
+		case liveReloadInvalidatePath:
+			ps.handleInvalidate(w, req)
+			return
+		}
+	}
+	if req.Method == http.MethodConnect {
+		ps.handleConnect(w, req)
+		return
+	}
+	ps.handleRequest(w, req)
+}
+
+// handleInvalidate serves POST /__cache_proxy/invalidate: the same action
+// triggered by the control socket or the TUI's 'i' key, exposed over HTTP so
+// an injected page can offer its own "reload now" affordance.
+func (ps *ProxyServer) handleInvalidate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ps.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// invalidate purges the cache and tells every connected live-reload client
+// to refresh. It's the single entry point shared by the invalidate HTTP
+// endpoint, the unix control socket, and the TUI's 'i' key.
+func (ps *ProxyServer) invalidate() {
+	ps.cache.Clear()
+	if ps.liveReload != nil {
+		ps.liveReload.Broadcast()
+	}
+}
+
+// handleConnect lets the proxy cache HTTPS traffic: it hijacks the client
+// connection, answers the CONNECT with "200 Connection Established", then
+// terminates TLS itself using a leaf certificate minted on the fly by ps.ca
+// for the requested host. The decrypted requests that come back out the
+// other side of that TLS connection are fed straight through serveHTTP, so
+// caching/Vary/etc. behave exactly as they do for plain HTTP. Clients must
+// trust ps.ca's root certificate (see --ca-cert) for this to work without a
+// TLS warning.
+func (ps *ProxyServer) handleConnect(w http.ResponseWriter, req *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		clientConn.Close()
+		return
+	}
+
+	host := req.URL.Hostname()
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// Clients connecting to an IP literal (as any
+			// httptest.NewTLSServer-backed origin does) send no SNI
+			// extension at all; fall back to the CONNECT target so
+			// interception still works.
+			sniHost := hello.ServerName
+			if sniHost == "" {
+				sniHost = host
+			}
+			return ps.ca.GetCertificateForHost(sniHost)
+		},
+	})
+
+	tunnel := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, innerReq *http.Request) {
+			innerReq.URL.Scheme = "https"
+			if innerReq.URL.Host == "" {
+				innerReq.URL.Host = host
+			}
+			ps.serveHTTP(w, innerReq)
+		}),
+	}
+	_ = tunnel.Serve(newSingleConnListener(tlsConn))
+}
+
+// singleConnListener adapts a single already-established net.Conn (here, a
+// freshly TLS-wrapped CONNECT tunnel) into the net.Listener http.Server.Serve
+// expects, so the standard library's request parsing/keep-alive handling can
+// be reused instead of reimplemented.
+type singleConnListener struct {
+	conns chan net.Conn
+	addr  net.Addr
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	conns := make(chan net.Conn, 1)
+	conns <- conn
+	close(conns)
+	return &singleConnListener{conns: conns, addr: conn.LocalAddr()}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, io.EOF
+	}
+	return conn, nil
+}
+
+func (l *singleConnListener) Close() error   { return nil }
+func (l *singleConnListener) Addr() net.Addr { return l.addr }
+
 func (ps *ProxyServer) Start() error {
-	http.HandleFunc("/", ps.handleRequest)
+	ps.pool.StartHealthChecks()
+
+	// Listening regardless of --live-reload is what lets `clear-cache`
+	// reach a running instance instead of silently clearing an unrelated,
+	// freshly-constructed empty store.
+	if err := ps.controlSock.ListenControlSocket(ps.invalidate); err != nil {
+		log.Printf("control socket unavailable: %v", err)
+	}
 
 	addr := fmt.Sprintf(":%d", ps.port)
 	log.Printf("Starting caching proxy server on port %d", ps.port)
-	log.Printf("Forwarding requests to: %s", ps.origin.String())
+	for _, upstream := range ps.pool.Snapshot() {
+		log.Printf("Forwarding requests to: %s", upstream.URL)
+	}
 	log.Printf("Cache size: %d entries", ps.cache.Size())
 
-	return http.ListenAndServe(addr, nil)
+	server := &http.Server{Addr: addr, Handler: http.HandlerFunc(ps.serveHTTP)}
+	return server.ListenAndServe()
 }
 
-func runTUI(port int, origin string) error {
+func runTUI(opts ProxyServerOptions) error {
 	p := tea.NewProgram(
-		NewModel(port, origin),
+		NewModel(opts),
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
@@ -473,56 +1082,144 @@ func runTUI(port int, origin string) error {
 
 func main() {
 	var (
-		port   int
-		origin string
-		tui    bool
+		port                       int
+		origins                    []string
+		upstreamsFile              string
+		tui                        bool
+		storeKind                  string
+		storePath                  string
+		maxEntrySize               int
+		maxCacheBytes              int
+		lbPolicy                   string
+		healthPath                 string
+		healthInterval             time.Duration
+		unhealthyThreshold         int
+		unhealthyWindow            time.Duration
+		maxRetries                 int
+		caCertPath                 string
+		caKeyPath                  string
+		upstreamProxy              string
+		upstreamProxyNoProxy       string
+		liveReload                 bool
+		insecureSkipVerifyUpstream bool
 	)
 
 	rootCmd := &cobra.Command{
 		Use:   "caching-proxy",
 		Short: "A caching proxy server with beautiful TUI",
 		Long: `A caching proxy server that forwards requests to origin servers and caches responses.
-Features a beautiful terminal user interface (TUI) for monitoring and control.`,
+Features a beautiful terminal user interface (TUI) for monitoring and control.
+
+HTTPS origins are cached by terminating TLS at the proxy: on first run it
+generates a root CA under ~/.caching-proxy/ca.{crt,key} (or loads one from
+--ca-cert/--ca-key), and mints a leaf certificate per host signed by that CA.
+For clients to accept those leaf certificates without a warning, install the
+generated ca.crt into the client's (or system's) trust store once, e.g. on
+Debian/Ubuntu: "cp ~/.caching-proxy/ca.crt /usr/local/share/ca-certificates/caching-proxy.crt && update-ca-certificates".`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if origin == "" {
-				return fmt.Errorf("origin URL is required")
+			if upstreamsFile != "" {
+				fromFile, err := LoadUpstreamsFile(upstreamsFile)
+				if err != nil {
+					return err
+				}
+				origins = append(origins, fromFile...)
+			}
+
+			if len(origins) == 0 {
+				return fmt.Errorf("at least one --origin (or --upstreams-file) is required")
 			}
 
 			if port <= 0 || port > 65535 {
 				return fmt.Errorf("port must be between 1 and 65535")
 			}
 
+			opts := ProxyServerOptions{
+				Origins:                    origins,
+				Port:                       port,
+				StoreKind:                  storeKind,
+				StorePath:                  storePath,
+				MaxEntrySize:               maxEntrySize,
+				MaxCacheBytes:              maxCacheBytes,
+				LBPolicy:                   lbPolicy,
+				HealthPath:                 healthPath,
+				HealthInterval:             healthInterval,
+				UnhealthyThreshold:         unhealthyThreshold,
+				UnhealthyWindow:            unhealthyWindow,
+				MaxRetries:                 maxRetries,
+				CACertPath:                 caCertPath,
+				CAKeyPath:                  caKeyPath,
+				UpstreamProxy:              upstreamProxy,
+				UpstreamProxyNoProxy:       upstreamProxyNoProxy,
+				LiveReload:                 liveReload,
+				InsecureSkipVerifyUpstream: insecureSkipVerifyUpstream,
+			}
+
 			if tui {
-				return runTUI(port, origin)
-			} else {
-				proxy, err := NewProxyServer(origin, port)
-				if err != nil {
-					return err
-				}
+				return runTUI(opts)
+			}
 
-				return proxy.Start()
+			proxy, err := NewProxyServerWithOptions(opts)
+			if err != nil {
+				return err
 			}
+
+			return proxy.Start()
 		},
 	}
 
 	clearCmd := &cobra.Command{
 		Use:   "clear-cache",
 		Short: "Clear the cache",
-		Run: func(cmd *cobra.Command, args []string) {
-			cache := NewCache()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Any running instance listens on control.sock (see
+			// ProxyServer.Start); prefer telling it to invalidate (which
+			// also broadcasts a reload to any connected live-reload
+			// browsers) over clearing the on-disk store out from under it.
+			if dialControlSocket() {
+				fmt.Println("Cache invalidated on running instance")
+				return nil
+			}
+
+			store, err := NewStore(storeKind, storePath)
+			if err != nil {
+				return err
+			}
+			cache := NewCacheWithStore(store)
 			cache.Clear()
+			if err := cache.Close(); err != nil {
+				return err
+			}
 			fmt.Println("Cache cleared successfully")
+			return nil
 		},
 	}
+	clearCmd.Flags().StringVar(&storeKind, "store", "memory", "Cache backend to clear (memory, bbolt, sqlite)")
+	clearCmd.Flags().StringVar(&storePath, "store-path", "", "Path to the store file (bbolt/sqlite); defaults under ~/.caching-proxy")
 
 	rootCmd.AddCommand(clearCmd)
 
 	rootCmd.Flags().IntVarP(&port, "port", "p", 0, "Port on which the caching proxy server will run")
-	rootCmd.Flags().StringVarP(&origin, "origin", "o", "", "URL of the server to which requests will be forwarded")
+	rootCmd.Flags().StringArrayVarP(&origins, "origin", "o", nil, "URL of a server to which requests will be forwarded (repeatable for load balancing)")
+	rootCmd.Flags().StringVar(&upstreamsFile, "upstreams-file", "", "YAML file listing upstream origins, as an alternative (or addition) to --origin")
 	rootCmd.Flags().BoolVarP(&tui, "tui", "t", false, "Enable beautiful terminal user interface")
+	rootCmd.Flags().StringVar(&storeKind, "store", "memory", "Cache backend to use (memory, bbolt, sqlite)")
+	rootCmd.Flags().StringVar(&storePath, "store-path", "", "Path to the store file (bbolt/sqlite); defaults under ~/.caching-proxy")
+	rootCmd.Flags().IntVar(&maxEntrySize, "max-entry-size", defaultMaxEntrySize, "Largest response body (in bytes) eligible for caching; larger responses stream through uncached")
+	rootCmd.Flags().IntVar(&maxCacheBytes, "max-cache-bytes", defaultMaxCacheBytes, "Total cache size budget in bytes before the LRU starts evicting")
+	rootCmd.Flags().StringVar(&lbPolicy, "lb-policy", "round_robin", "Load-balancing policy across multiple origins (round_robin, least_conn, ip_hash, random)")
+	rootCmd.Flags().StringVar(&healthPath, "health-path", "/", "Path to GET on each origin for active health checks")
+	rootCmd.Flags().DurationVar(&healthInterval, "health-interval", 10*time.Second, "Interval between active health checks")
+	rootCmd.Flags().IntVar(&unhealthyThreshold, "unhealthy-threshold", 3, "Consecutive failures within --unhealthy-window before an origin is marked unhealthy")
+	rootCmd.Flags().DurationVar(&unhealthyWindow, "unhealthy-window", 30*time.Second, "Sliding window over which failures count toward --unhealthy-threshold")
+	rootCmd.Flags().IntVar(&maxRetries, "max-retries", 0, "Number of times to retry a request against another origin after a connection failure or 5xx response")
+	rootCmd.Flags().StringVar(&caCertPath, "ca-cert", "", "Path to the root CA certificate used to mint leaf certs for HTTPS interception; generated under ~/.caching-proxy/ca.crt if absent")
+	rootCmd.Flags().StringVar(&caKeyPath, "ca-key", "", "Path to the root CA private key; generated under ~/.caching-proxy/ca.key if absent")
+	rootCmd.Flags().StringVar(&upstreamProxy, "upstream-proxy", "", "Forward all origin requests through this proxy (http://, https://, or socks5://; credentials may be embedded as user:pw@host)")
+	rootCmd.Flags().StringVar(&upstreamProxyNoProxy, "upstream-proxy-noproxy", "", "Comma-separated host globs (e.g. '*.internal.example.com') that bypass --upstream-proxy")
+	rootCmd.Flags().BoolVar(&liveReload, "live-reload", false, "Inject a live-reload <script> into cache-miss text/html responses, and expose /__cache_proxy/reload + /__cache_proxy/invalidate")
+	rootCmd.Flags().BoolVar(&insecureSkipVerifyUpstream, "insecure-skip-verify-upstream", false, "Skip certificate verification when re-dialing an origin after HTTPS interception; needed for origins with a self-signed or privately-issued certificate")
 
 	rootCmd.MarkFlagRequired("port")
-	rootCmd.MarkFlagRequired("origin")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 )
@@ -39,25 +45,64 @@ func TestCache(t *testing.T) {
 	}
 }
 
-func TestCacheExpiration(t *testing.T) {
-	cache := NewCache()
+func TestCacheEntryFreshness(t *testing.T) {
+	fresh := &CacheEntry{
+		Timestamp: time.Now(),
+		TTL:       1 * time.Minute,
+	}
+	if !fresh.isFresh() {
+		t.Error("Expected entry within its TTL to be fresh")
+	}
 
-	entry := &CacheEntry{
-		Body:       []byte("expired response"),
-		Headers:    http.Header{},
-		StatusCode: 200,
-		Timestamp:  time.Now().Add(-2 * time.Minute), // 2 minutes ago
-		TTL:        1 * time.Minute,                  // 1 minute TTL
+	stale := &CacheEntry{
+		Timestamp: time.Now().Add(-2 * time.Minute),
+		TTL:       1 * time.Minute,
+	}
+	if stale.isFresh() {
+		t.Error("Expected entry past its TTL to be stale")
 	}
+}
 
-	cache.Set("expired-key", entry)
+func TestServableFromCache(t *testing.T) {
+	proxy, _ := NewProxyServer("http://example.com", 8080)
 
-	if _, hit := cache.Get("expired-key"); hit {
-		t.Error("Expected cache miss for expired entry, got hit")
+	fresh := &CacheEntry{Timestamp: time.Now(), TTL: 1 * time.Minute}
+	if !proxy.servableFromCache(fresh, requestCacheControl{}) {
+		t.Error("Expected fresh entry to be servable")
 	}
 
-	if size := cache.Size(); size != 0 {
-		t.Errorf("Expected cache size 0 after expiration, got %d", size)
+	if proxy.servableFromCache(fresh, requestCacheControl{noCache: true}) {
+		t.Error("Expected no-cache request directive to force revalidation")
+	}
+
+	stale := &CacheEntry{Timestamp: time.Now().Add(-2 * time.Minute), TTL: 1 * time.Minute}
+	if proxy.servableFromCache(stale, requestCacheControl{}) {
+		t.Error("Expected stale entry to be unservable without max-stale")
+	}
+
+	if !proxy.servableFromCache(stale, requestCacheControl{maxStaleSet: true, maxStale: 5 * time.Minute}) {
+		t.Error("Expected max-stale to permit a moderately stale entry")
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	if isCacheable(200, http.Header{}, responseCacheControl{noStore: true}) {
+		t.Error("Expected no-store response to be uncacheable")
+	}
+	if isCacheable(200, http.Header{}, responseCacheControl{private: true}) {
+		t.Error("Expected private response to be uncacheable")
+	}
+	if isCacheable(200, http.Header{"Set-Cookie": {"id=1"}}, responseCacheControl{}) {
+		t.Error("Expected Set-Cookie without public to be uncacheable")
+	}
+	if !isCacheable(200, http.Header{"Set-Cookie": {"id=1"}}, responseCacheControl{public: true}) {
+		t.Error("Expected Set-Cookie with public to be cacheable")
+	}
+	if !isCacheable(200, http.Header{}, responseCacheControl{}) {
+		t.Error("Expected a plain 200 to be cacheable")
+	}
+	if isCacheable(500, http.Header{}, responseCacheControl{}) {
+		t.Error("Expected a 5xx response to be uncacheable")
 	}
 }
 
@@ -69,8 +114,9 @@ func TestProxyServerCreation(t *testing.T) {
 	if proxy.port != 8080 {
 		t.Errorf("Expected port 8080, got %d", proxy.port)
 	}
-	if proxy.origin.String() != "http://example.com" {
-		t.Errorf("Expected origin 'http://example.com', got '%s'", proxy.origin.String())
+	snapshot := proxy.pool.Snapshot()
+	if len(snapshot) != 1 || snapshot[0].URL != "http://example.com" {
+		t.Errorf("Expected a single upstream 'http://example.com', got %v", snapshot)
 	}
 
 	_, err = NewProxyServer("://invalid-url", 8080)
@@ -79,14 +125,58 @@ func TestProxyServerCreation(t *testing.T) {
 	}
 }
 
+// TestHandleRequestDoesNotLeakEvictionHeaderIntoStoredEntry reproduces the
+// aliasing bug that left X-Cache-Evicted baked into a stored entry: since
+// cacheEntry.Headers and recorder.headers used to be the same map, setting
+// the diagnostic header on recorder.headers after ps.cache.Set committed
+// the entry mutated the entry too, so every later HIT for that key kept
+// reporting an eviction that only happened once, at write time.
+func TestHandleRequestDoesNotLeakEvictionHeaderIntoStoredEntry(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer origin.Close()
+
+	proxy, err := NewProxyServerWithOptions(ProxyServerOptions{
+		Origin:        origin.URL,
+		Port:          0,
+		MaxCacheBytes: 15, // smaller than any one entry with its headers, so each Set evicts every older entry
+	})
+	if err != nil {
+		t.Fatalf("Expected no error building proxy, got %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		proxy.handleRequest(rec, req)
+		return rec
+	}
+
+	get("/a")                 // first entry, no eviction yet
+	evictingResp := get("/b") // stored over budget, evicts "/a"
+	if got := evictingResp.Header().Get("X-Cache-Evicted"); got != "true" {
+		t.Fatalf("Expected the write that evicts '/a' to report X-Cache-Evicted, got %q", got)
+	}
+
+	hitResp := get("/b") // should now be a HIT against the entry stored above
+	if got := hitResp.Header().Get("X-Cache"); got != "HIT" {
+		t.Fatalf("Expected '/b' to be served from cache, got X-Cache %q", got)
+	}
+	if got := hitResp.Header().Get("X-Cache-Evicted"); got != "" {
+		t.Errorf("Expected a cache HIT to never report X-Cache-Evicted, got %q", got)
+	}
+}
+
 func TestCacheKeyGeneration(t *testing.T) {
 	proxy, _ := NewProxyServer("http://example.com", 8080)
 
 	req, _ := http.NewRequest("GET", "http://localhost:8080/test", nil)
 	req.Header.Set("User-Agent", "test-agent")
 
-	key1 := proxy.generateCacheKey(req)
-	key2 := proxy.generateCacheKey(req)
+	key1 := proxy.generateCacheKey(req, nil)
+	key2 := proxy.generateCacheKey(req, nil)
 
 	if key1 != key2 {
 		t.Error("Expected same cache key for same request")
@@ -94,9 +184,171 @@ func TestCacheKeyGeneration(t *testing.T) {
 
 	req2, _ := http.NewRequest("POST", "http://localhost:8080/test", nil)
 	req2.Header.Set("User-Agent", "test-agent")
-	key3 := proxy.generateCacheKey(req2)
+	key3 := proxy.generateCacheKey(req2, nil)
 
 	if key1 == key3 {
 		t.Error("Expected different cache keys for different requests")
 	}
 }
+
+func TestCacheKeyGenerationWithVary(t *testing.T) {
+	proxy, _ := NewProxyServer("http://example.com", 8080)
+
+	req1, _ := http.NewRequest("GET", "http://localhost:8080/test", nil)
+	req1.Header.Set("Accept-Encoding", "gzip")
+
+	req2, _ := http.NewRequest("GET", "http://localhost:8080/test", nil)
+	req2.Header.Set("Accept-Encoding", "br")
+
+	varyHeaders := []string{"accept-encoding"}
+
+	if proxy.generateCacheKey(req1, varyHeaders) == proxy.generateCacheKey(req2, varyHeaders) {
+		t.Error("Expected different cache keys for different Vary-listed header values")
+	}
+
+	if proxy.generateCacheKey(req1, nil) != proxy.generateCacheKey(req2, nil) {
+		t.Error("Expected same cache key when no Vary headers are tracked")
+	}
+}
+
+// TestHTTPSInterceptionServesSecondRequestFromCache exercises the full
+// CONNECT -> TLS termination -> handleRequest path against a TLS origin,
+// asserting that the second request through the tunnel is served from
+// cache rather than forwarded again.
+func TestHTTPSInterceptionServesSecondRequestFromCache(t *testing.T) {
+	var hits int
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}))
+	defer origin.Close()
+
+	caDir := t.TempDir()
+	proxy, err := NewProxyServerWithOptions(ProxyServerOptions{
+		Origin:                     origin.URL,
+		Port:                       0,
+		CACertPath:                 caDir + "/ca.crt",
+		CAKeyPath:                  caDir + "/ca.key",
+		InsecureSkipVerifyUpstream: true, // origin.URL is httptest.NewTLSServer's self-signed cert
+	})
+	if err != nil {
+		t.Fatalf("Expected no error building proxy, got %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	proxyServer := &http.Server{Handler: http.HandlerFunc(proxy.serveHTTP)}
+	go proxyServer.Serve(listener)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: listener.Addr().String()}),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	resp1, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatalf("First request failed: %v", err)
+	}
+	resp1.Body.Close()
+	if got := resp1.Header.Get("X-Cache"); got != "MISS" {
+		t.Errorf("Expected X-Cache MISS on first request, got %q", got)
+	}
+
+	resp2, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatalf("Second request failed: %v", err)
+	}
+	resp2.Body.Close()
+	if got := resp2.Header.Get("X-Cache"); got != "HIT" {
+		t.Errorf("Expected X-Cache HIT on second request, got %q", got)
+	}
+
+	if hits != 1 {
+		t.Errorf("Expected the origin to be hit exactly once, got %d", hits)
+	}
+}
+
+// TestForwardRetriesOnServerError asserts that a 5xx response from one
+// upstream is retried against the next healthy upstream, not returned
+// straight to the client - the response isn't written to anything but an
+// in-memory recorder until handleRequest decides to flush it, so a retry
+// here can never double-send.
+func TestForwardRetriesOnServerError(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	proxy, err := NewProxyServerWithOptions(ProxyServerOptions{
+		Origins:    []string{failing.URL, healthy.URL},
+		Port:       0,
+		LBPolicy:   "round_robin",
+		MaxRetries: 1,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error building proxy, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	proxy.handleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the retry to reach the healthy upstream (200), got %d", rec.Code)
+	}
+	if body := rec.Body.String(); body != "ok" {
+		t.Errorf("Expected body %q from the healthy upstream, got %q", "ok", body)
+	}
+}
+
+// TestForwardRetriesReplayRequestBody reproduces the bug a retry would
+// otherwise hit for any POST/PUT/PATCH: req.Clone (used by
+// forwardToUpstream) only copies the Body pointer, not its contents, so
+// without buffering and replaying the body up front, the first attempt
+// drains it and the retry sends the healthy upstream an empty body.
+func TestForwardRetriesReplayRequestBody(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var gotBody string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	proxy, err := NewProxyServerWithOptions(ProxyServerOptions{
+		Origins:    []string{failing.URL, healthy.URL},
+		Port:       0,
+		LBPolicy:   "round_robin",
+		MaxRetries: 1,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error building proxy, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload"))
+	rec := httptest.NewRecorder()
+	proxy.handleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the retry to reach the healthy upstream (200), got %d", rec.Code)
+	}
+	if gotBody != "payload" {
+		t.Errorf("Expected the healthy upstream to receive the full request body, got %q", gotBody)
+	}
+}
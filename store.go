@@ -0,0 +1,545 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	_ "modernc.org/sqlite"
+)
+
+// Store is the persistence interface for cached responses. Cache delegates
+// all storage to whichever Store implementation is selected via --store,
+// so swapping backends never touches the caching/Vary logic in cache.go.
+//
+// VaryHeaders/SetVaryHeaders persist the per-base-key Vary index alongside
+// the entries themselves: generateCacheKey folds that index into the MD5
+// key it stores an entry under, so if a bbolt/sqlite-backed index didn't
+// survive a process restart, a restarted process would recompute a
+// different key than the one the entry actually lives under and the entry
+// would become permanently unreachable.
+type Store interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+	Iterate(fn func(key string, entry *CacheEntry) bool)
+	Size() int
+	Close() error
+
+	VaryHeaders(baseKey string) ([]string, bool)
+	SetVaryHeaders(baseKey string, varyHeaders []string)
+	ClearVaryHeaders()
+}
+
+const compactionInterval = 1 * time.Minute
+
+// defaultStoreDir returns ~/.caching-proxy, creating it if necessary, for
+// stores that default to a file under the user's home directory.
+func defaultStoreDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home directory: %v", err)
+	}
+	dir := filepath.Join(home, ".caching-proxy")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create store directory: %v", err)
+	}
+	return dir, nil
+}
+
+// NewStore builds a Store for the given backend name ("memory", "bbolt",
+// or "sqlite"). path is the backend's file location; an empty path falls
+// back to a default under ~/.caching-proxy.
+func NewStore(kind, path string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return newMemoryStore(), nil
+	case "bbolt":
+		if path == "" {
+			dir, err := defaultStoreDir()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(dir, "cache.bbolt")
+		}
+		return newBboltStore(path)
+	case "sqlite":
+		if path == "" {
+			dir, err := defaultStoreDir()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(dir, "cache.sqlite")
+		}
+		return newSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q (want memory, bbolt, or sqlite)", kind)
+	}
+}
+
+// memoryStore is the original in-process map-backed store.
+type memoryStore struct {
+	entries   map[string]*CacheEntry
+	varyIndex map[string][]string
+	mutex     sync.RWMutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		entries:   make(map[string]*CacheEntry),
+		varyIndex: make(map[string][]string),
+	}
+}
+
+func (s *memoryStore) Get(key string) (*CacheEntry, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entry, ok := s.entries[key]
+	return entry, ok
+}
+
+func (s *memoryStore) Set(key string, entry *CacheEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[key] = entry
+}
+
+func (s *memoryStore) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, key)
+}
+
+func (s *memoryStore) Iterate(fn func(key string, entry *CacheEntry) bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for key, entry := range s.entries {
+		if !fn(key, entry) {
+			return
+		}
+	}
+}
+
+func (s *memoryStore) Size() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.entries)
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) VaryHeaders(baseKey string) ([]string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	headers, ok := s.varyIndex[baseKey]
+	return headers, ok
+}
+
+func (s *memoryStore) SetVaryHeaders(baseKey string, varyHeaders []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(varyHeaders) == 0 {
+		delete(s.varyIndex, baseKey)
+		return
+	}
+	s.varyIndex[baseKey] = varyHeaders
+}
+
+func (s *memoryStore) ClearVaryHeaders() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.varyIndex = make(map[string][]string)
+}
+
+// bboltStore persists entries as JSON values in a single bbolt bucket,
+// giving us a durable single-file cache without requiring cgo. The Vary
+// index (see Store) lives in its own bucket so it survives a restart
+// alongside the entries it governs the keys of.
+type bboltStore struct {
+	db         *bbolt.DB
+	bucket     []byte
+	varyBucket []byte
+	stopCh     chan struct{}
+}
+
+var (
+	bboltBucketName     = []byte("cache")
+	bboltVaryBucketName = []byte("vary")
+)
+
+func newBboltStore(path string) (*bboltStore, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bbolt store at %s: %v", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bboltBucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bboltVaryBucketName)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize bbolt bucket: %v", err)
+	}
+
+	s := &bboltStore{db: db, bucket: bboltBucketName, varyBucket: bboltVaryBucketName, stopCh: make(chan struct{})}
+	go s.compactLoop()
+	return s, nil
+}
+
+func (s *bboltStore) Get(key string) (*CacheEntry, bool) {
+	var entry *CacheEntry
+	s.db.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(s.bucket).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		var e CacheEntry
+		if err := json.Unmarshal(raw, &e); err == nil {
+			entry = &e
+		}
+		return nil
+	})
+	return entry, entry != nil
+}
+
+func (s *bboltStore) Set(key string, entry *CacheEntry) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("bbolt store: marshal entry for %s: %v", key, err)
+		return
+	}
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), raw)
+	}); err != nil {
+		log.Printf("bbolt store: put %s: %v", key, err)
+	}
+}
+
+func (s *bboltStore) Delete(key string) {
+	s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(key))
+	})
+}
+
+func (s *bboltStore) Iterate(fn func(key string, entry *CacheEntry) bool) {
+	s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e CacheEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				continue
+			}
+			if !fn(string(k), &e) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *bboltStore) Size() int {
+	size := 0
+	s.db.View(func(tx *bbolt.Tx) error {
+		size = tx.Bucket(s.bucket).Stats().KeyN
+		return nil
+	})
+	return size
+}
+
+func (s *bboltStore) Close() error {
+	close(s.stopCh)
+	return s.db.Close()
+}
+
+func (s *bboltStore) compactLoop() {
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.evictExpired()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *bboltStore) VaryHeaders(baseKey string) ([]string, bool) {
+	var headers []string
+	var found bool
+	s.db.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(s.varyBucket).Get([]byte(baseKey))
+		if raw == nil {
+			return nil
+		}
+		found = json.Unmarshal(raw, &headers) == nil
+		return nil
+	})
+	return headers, found
+}
+
+func (s *bboltStore) SetVaryHeaders(baseKey string, varyHeaders []string) {
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		if len(varyHeaders) == 0 {
+			return tx.Bucket(s.varyBucket).Delete([]byte(baseKey))
+		}
+		raw, err := json.Marshal(varyHeaders)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(s.varyBucket).Put([]byte(baseKey), raw)
+	}); err != nil {
+		log.Printf("bbolt store: set vary headers for %s: %v", baseKey, err)
+	}
+}
+
+func (s *bboltStore) ClearVaryHeaders() {
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(s.varyBucket); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucket(s.varyBucket)
+		return err
+	}); err != nil {
+		log.Printf("bbolt store: clear vary headers: %v", err)
+	}
+}
+
+func (s *bboltStore) evictExpired() {
+	var expiredKeys [][]byte
+	s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e CacheEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				continue
+			}
+			if !e.isFresh() {
+				expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+			}
+		}
+		return nil
+	})
+	if len(expiredKeys) == 0 {
+		return
+	}
+	s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, k := range expiredKeys {
+			b.Delete(k)
+		}
+		return nil
+	})
+}
+
+// sqliteStore persists entries in a SQLite table, using modernc.org/sqlite
+// so the binary stays cgo-free.
+type sqliteStore struct {
+	db     *sql.DB
+	stopCh chan struct{}
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS cache_entries (
+	key             TEXT PRIMARY KEY,
+	body            BLOB NOT NULL,
+	headers         TEXT NOT NULL,
+	status          INTEGER NOT NULL,
+	created_at      INTEGER NOT NULL,
+	ttl_ns          INTEGER NOT NULL,
+	vary            TEXT NOT NULL DEFAULT '',
+	must_revalidate INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_cache_entries_created_at ON cache_entries(created_at);
+CREATE TABLE IF NOT EXISTS vary_index (
+	base_key TEXT PRIMARY KEY,
+	headers  TEXT NOT NULL
+);
+`
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite store at %s: %v", path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize sqlite schema: %v", err)
+	}
+	// A store file written before must_revalidate existed won't have the
+	// column yet; CREATE TABLE IF NOT EXISTS above is a no-op against it, so
+	// add it here. The error is ignored because it's expected (and harmless)
+	// once the column is already present.
+	db.Exec(`ALTER TABLE cache_entries ADD COLUMN must_revalidate INTEGER NOT NULL DEFAULT 0`)
+
+	s := &sqliteStore{db: db, stopCh: make(chan struct{})}
+	go s.compactLoop()
+	return s, nil
+}
+
+func (s *sqliteStore) Get(key string) (*CacheEntry, bool) {
+	row := s.db.QueryRow(
+		`SELECT body, headers, status, created_at, ttl_ns, vary, must_revalidate FROM cache_entries WHERE key = ?`, key)
+	entry, err := scanCacheEntryRow(row.Scan)
+	if err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (s *sqliteStore) Set(key string, entry *CacheEntry) {
+	headers, err := json.Marshal(entry.Headers)
+	if err != nil {
+		log.Printf("sqlite store: marshal headers for %s: %v", key, err)
+		return
+	}
+	vary, err := json.Marshal(entry.Vary)
+	if err != nil {
+		log.Printf("sqlite store: marshal vary for %s: %v", key, err)
+		return
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO cache_entries (key, body, headers, status, created_at, ttl_ns, vary, must_revalidate)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET
+			body = excluded.body, headers = excluded.headers, status = excluded.status,
+			created_at = excluded.created_at, ttl_ns = excluded.ttl_ns, vary = excluded.vary,
+			must_revalidate = excluded.must_revalidate`,
+		key, entry.Body, headers, entry.StatusCode, entry.Timestamp.UnixNano(), int64(entry.TTL), vary,
+		entry.MustRevalidate)
+	if err != nil {
+		log.Printf("sqlite store: upsert %s: %v", key, err)
+	}
+}
+
+func (s *sqliteStore) Delete(key string) {
+	s.db.Exec(`DELETE FROM cache_entries WHERE key = ?`, key)
+}
+
+func (s *sqliteStore) Iterate(fn func(key string, entry *CacheEntry) bool) {
+	rows, err := s.db.Query(`SELECT key, body, headers, status, created_at, ttl_ns, vary, must_revalidate FROM cache_entries`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var headers, vary []byte
+		var body []byte
+		var status int
+		var createdAt, ttlNs int64
+		var mustRevalidate bool
+		if err := rows.Scan(&key, &body, &headers, &status, &createdAt, &ttlNs, &vary, &mustRevalidate); err != nil {
+			continue
+		}
+		entry, err := decodeCacheEntryRow(body, headers, vary, status, createdAt, ttlNs, mustRevalidate)
+		if err != nil {
+			continue
+		}
+		if !fn(key, entry) {
+			return
+		}
+	}
+}
+
+func (s *sqliteStore) Size() int {
+	var count int
+	s.db.QueryRow(`SELECT COUNT(*) FROM cache_entries`).Scan(&count)
+	return count
+}
+
+func (s *sqliteStore) Close() error {
+	close(s.stopCh)
+	return s.db.Close()
+}
+
+func (s *sqliteStore) compactLoop() {
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.db.Exec(`DELETE FROM cache_entries WHERE created_at + ttl_ns < ?`, time.Now().UnixNano())
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *sqliteStore) VaryHeaders(baseKey string) ([]string, bool) {
+	var raw []byte
+	err := s.db.QueryRow(`SELECT headers FROM vary_index WHERE base_key = ?`, baseKey).Scan(&raw)
+	if err != nil {
+		return nil, false
+	}
+	var headers []string
+	if err := json.Unmarshal(raw, &headers); err != nil {
+		return nil, false
+	}
+	return headers, true
+}
+
+func (s *sqliteStore) SetVaryHeaders(baseKey string, varyHeaders []string) {
+	if len(varyHeaders) == 0 {
+		s.db.Exec(`DELETE FROM vary_index WHERE base_key = ?`, baseKey)
+		return
+	}
+	raw, err := json.Marshal(varyHeaders)
+	if err != nil {
+		log.Printf("sqlite store: marshal vary headers for %s: %v", baseKey, err)
+		return
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO vary_index (base_key, headers) VALUES (?, ?)
+		 ON CONFLICT(base_key) DO UPDATE SET headers = excluded.headers`,
+		baseKey, raw); err != nil {
+		log.Printf("sqlite store: upsert vary headers for %s: %v", baseKey, err)
+	}
+}
+
+func (s *sqliteStore) ClearVaryHeaders() {
+	s.db.Exec(`DELETE FROM vary_index`)
+}
+
+func scanCacheEntryRow(scan func(dest ...any) error) (*CacheEntry, error) {
+	var body, headers, vary []byte
+	var status int
+	var createdAt, ttlNs int64
+	var mustRevalidate bool
+	if err := scan(&body, &headers, &status, &createdAt, &ttlNs, &vary, &mustRevalidate); err != nil {
+		return nil, err
+	}
+	return decodeCacheEntryRow(body, headers, vary, status, createdAt, ttlNs, mustRevalidate)
+}
+
+func decodeCacheEntryRow(body, headers, vary []byte, status int, createdAt, ttlNs int64, mustRevalidate bool) (*CacheEntry, error) {
+	var h map[string][]string
+	if err := json.Unmarshal(headers, &h); err != nil {
+		return nil, err
+	}
+	var varyHeaders []string
+	if len(vary) > 0 {
+		json.Unmarshal(vary, &varyHeaders)
+	}
+	return &CacheEntry{
+		Body:           body,
+		Headers:        h,
+		StatusCode:     status,
+		Timestamp:      time.Unix(0, createdAt),
+		TTL:            time.Duration(ttlNs),
+		Vary:           varyHeaders,
+		MustRevalidate: mustRevalidate,
+	}, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sampleEntry() *CacheEntry {
+	return &CacheEntry{
+		Body:       []byte("persisted response"),
+		Headers:    http.Header{"Content-Type": {"text/plain"}},
+		StatusCode: 200,
+		Timestamp:  time.Now(),
+		TTL:        5 * time.Minute,
+		Vary:       []string{"accept-encoding"},
+	}
+}
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	store := newMemoryStore()
+	defer store.Close()
+
+	store.Set("key", sampleEntry())
+
+	if _, hit := store.Get("key"); !hit {
+		t.Fatal("Expected hit after Set")
+	}
+	if size := store.Size(); size != 1 {
+		t.Errorf("Expected size 1, got %d", size)
+	}
+
+	store.Delete("key")
+	if _, hit := store.Get("key"); hit {
+		t.Error("Expected miss after Delete")
+	}
+}
+
+func TestBboltStoreMigrationAcrossProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.bbolt")
+
+	writer, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("open bbolt store: %v", err)
+	}
+	writer.Set("key", sampleEntry())
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer store: %v", err)
+	}
+
+	reader, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("reopen bbolt store: %v", err)
+	}
+	defer reader.Close()
+
+	entry, hit := reader.Get("key")
+	if !hit {
+		t.Fatal("Expected entry written by the first store to survive reopen")
+	}
+	if string(entry.Body) != "persisted response" {
+		t.Errorf("Expected body to round-trip, got %q", string(entry.Body))
+	}
+	if len(entry.Vary) != 1 || entry.Vary[0] != "accept-encoding" {
+		t.Errorf("Expected Vary to round-trip, got %v", entry.Vary)
+	}
+}
+
+func TestBboltStoreVaryHeadersSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.bbolt")
+
+	writer, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("open bbolt store: %v", err)
+	}
+	writer.SetVaryHeaders("base-key", []string{"accept-encoding"})
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer store: %v", err)
+	}
+
+	reader, err := newBboltStore(path)
+	if err != nil {
+		t.Fatalf("reopen bbolt store: %v", err)
+	}
+	defer reader.Close()
+
+	headers, ok := reader.VaryHeaders("base-key")
+	if !ok {
+		t.Fatal("Expected vary headers written by the first store to survive reopen")
+	}
+	if len(headers) != 1 || headers[0] != "accept-encoding" {
+		t.Errorf("Expected vary headers to round-trip, got %v", headers)
+	}
+}
+
+func TestSQLiteStoreMigrationAcrossProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.sqlite")
+
+	writer, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("open sqlite store: %v", err)
+	}
+	writer.Set("key", sampleEntry())
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer store: %v", err)
+	}
+
+	reader, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen sqlite store: %v", err)
+	}
+	defer reader.Close()
+
+	entry, hit := reader.Get("key")
+	if !hit {
+		t.Fatal("Expected entry written by the first store to survive reopen")
+	}
+	if entry.StatusCode != 200 {
+		t.Errorf("Expected status 200, got %d", entry.StatusCode)
+	}
+	if reader.Size() != 1 {
+		t.Errorf("Expected size 1, got %d", reader.Size())
+	}
+}
+
+func TestSQLiteStoreVaryHeadersSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.sqlite")
+
+	writer, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("open sqlite store: %v", err)
+	}
+	writer.SetVaryHeaders("base-key", []string{"accept-encoding"})
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer store: %v", err)
+	}
+
+	reader, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen sqlite store: %v", err)
+	}
+	defer reader.Close()
+
+	headers, ok := reader.VaryHeaders("base-key")
+	if !ok {
+		t.Fatal("Expected vary headers written by the first store to survive reopen")
+	}
+	if len(headers) != 1 || headers[0] != "accept-encoding" {
+		t.Errorf("Expected vary headers to round-trip, got %v", headers)
+	}
+}
+
+func TestNewStoreUnknownBackend(t *testing.T) {
+	if _, err := NewStore("redis", ""); err == nil {
+		t.Error("Expected an error for an unsupported store backend")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// NewUpstreamTransport builds the *http.Transport this proxy uses to reach
+// origins, including the outbound leg of an HTTPS-intercepted request once
+// handleConnect has terminated the client's TLS connection. With
+// upstreamProxyURL empty it behaves like http.DefaultTransport. Otherwise
+// every outbound request -- including CONNECT-tunneled HTTPS requests once
+// TLS has been terminated -- is routed through that upstream proxy (http://,
+// https://, or socks5://, with credentials taken from the URL's userinfo),
+// except for hosts matching one of the noProxy globs, which dial the origin
+// directly. insecureSkipVerify disables certificate verification on that
+// outbound leg, for origins presenting a self-signed or otherwise
+// privately-trusted certificate (see --insecure-skip-verify-upstream).
+func NewUpstreamTransport(upstreamProxyURL string, noProxy []string, insecureSkipVerify bool) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if upstreamProxyURL == "" {
+		return transport, nil
+	}
+
+	proxyURL, err := url.Parse(upstreamProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --upstream-proxy URL: %v", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("build SOCKS5 dialer: %v", err)
+		}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if bypassesProxy(addr, noProxy) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			}
+			return dialViaSOCKS5(ctx, dialer, network, addr)
+		}
+	default:
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypassesProxy(req.URL.Host, noProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+
+	return transport, nil
+}
+
+// splitNoProxyList parses the comma-separated --upstream-proxy-noproxy value
+// into individual host globs.
+func splitNoProxyList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	parts := strings.Split(raw, ",")
+	list := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// bypassesProxy reports whether hostport's host matches one of the noProxy
+// globs (matched with path.Match, e.g. "*.internal.example.com").
+func bypassesProxy(hostport string, noProxy []string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	for _, pattern := range noProxy {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dialViaSOCKS5 adapts a golang.org/x/net/proxy.Dialer, whose Dial method is
+// blocking, to DialContext semantics so it can back an http.Transport.
+func dialViaSOCKS5(ctx context.Context, dialer proxy.Dialer, network, addr string) (net.Conn, error) {
+	if ctxDialer, ok := dialer.(proxy.ContextDialer); ok {
+		return ctxDialer.DialContext(ctx, network, addr)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dialer.Dial(network, addr)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		return res.conn, res.err
+	}
+}
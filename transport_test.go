@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBypassesProxyMatchesGlobs(t *testing.T) {
+	noProxy := []string{"*.internal.example.com", "localhost"}
+
+	if !bypassesProxy("svc.internal.example.com:8080", noProxy) {
+		t.Error("Expected a glob match to bypass the proxy")
+	}
+	if !bypassesProxy("localhost:9000", noProxy) {
+		t.Error("Expected an exact host match to bypass the proxy")
+	}
+	if bypassesProxy("example.com:443", noProxy) {
+		t.Error("Expected a non-matching host to use the proxy")
+	}
+}
+
+func TestUpstreamTransportNoProxyBypassesUpstream(t *testing.T) {
+	transport, err := NewUpstreamTransport("http://corp-proxy:3128", []string{"*.internal.example.com"}, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	bypassed, _ := http.NewRequest("GET", "http://svc.internal.example.com/", nil)
+	if proxyURL, err := transport.Proxy(bypassed); err != nil || proxyURL != nil {
+		t.Errorf("Expected a no-proxy match to bypass the upstream proxy, got (%v, %v)", proxyURL, err)
+	}
+
+	routed, _ := http.NewRequest("GET", "http://example.com/", nil)
+	proxyURL, err := transport.Proxy(routed)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if proxyURL == nil || proxyURL.Host != "corp-proxy:3128" {
+		t.Errorf("Expected a non-matching host to use the upstream proxy, got %v", proxyURL)
+	}
+}
+
+func TestUpstreamTransportSendsProxyAuthorization(t *testing.T) {
+	var gotAuth string
+	upstreamProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstreamProxy.Close()
+
+	proxyURL := strings.Replace(upstreamProxy.URL, "http://", "http://user:pw@", 1)
+	transport, err := NewUpstreamTransport(proxyURL, nil, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("Request through upstream proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if gotAuth == "" {
+		t.Error("Expected Proxy-Authorization header to be sent to the upstream proxy")
+	}
+}
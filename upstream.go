@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Upstream is one origin server in an UpstreamPool, along with the health
+// and load bookkeeping the selector and reverse proxy need.
+type Upstream struct {
+	URL *url.URL
+
+	Healthy  atomic.Bool
+	InFlight atomic.Int64
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	failureTimestamps   []time.Time
+	lastError           string
+}
+
+func newUpstream(u *url.URL) *Upstream {
+	up := &Upstream{URL: u}
+	up.Healthy.Store(true)
+	return up
+}
+
+func (u *Upstream) LastError() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.lastError
+}
+
+// recordFailure tracks a passive failure (5xx or connection error) in a
+// sliding window and flips Healthy off once unhealthyThreshold failures
+// land within unhealthyWindow.
+func (u *Upstream) recordFailure(err error, threshold int, window time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	if err != nil {
+		u.lastError = err.Error()
+	}
+
+	cutoff := now.Add(-window)
+	kept := u.failureTimestamps[:0]
+	for _, ts := range u.failureTimestamps {
+		if ts.After(cutoff) {
+			kept = append(kept, ts)
+		}
+	}
+	u.failureTimestamps = append(kept, now)
+
+	if len(u.failureTimestamps) >= threshold {
+		u.Healthy.Store(false)
+	}
+}
+
+func (u *Upstream) recordSuccess() {
+	u.mu.Lock()
+	u.failureTimestamps = nil
+	u.lastError = ""
+	u.mu.Unlock()
+}
+
+// UpstreamPoolOptions configures load balancing, active health checks, and
+// passive failure detection for an UpstreamPool.
+type UpstreamPoolOptions struct {
+	Policy             string // round_robin, least_conn, ip_hash, random
+	HealthPath         string
+	HealthInterval     time.Duration
+	UnhealthyThreshold int
+	UnhealthyWindow    time.Duration
+	MaxRetries         int
+}
+
+func (o *UpstreamPoolOptions) applyDefaults() {
+	if o.Policy == "" {
+		o.Policy = "round_robin"
+	}
+	if o.HealthPath == "" {
+		o.HealthPath = "/"
+	}
+	if o.HealthInterval <= 0 {
+		o.HealthInterval = 10 * time.Second
+	}
+	if o.UnhealthyThreshold <= 0 {
+		o.UnhealthyThreshold = 3
+	}
+	if o.UnhealthyWindow <= 0 {
+		o.UnhealthyWindow = 30 * time.Second
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+}
+
+// UpstreamPool load-balances across N origins, skipping any an active or
+// passive health check has marked unhealthy.
+type UpstreamPool struct {
+	upstreams []*Upstream
+	opts      UpstreamPoolOptions
+
+	counter atomic.Uint64
+
+	stopCh chan struct{}
+}
+
+// NewUpstreamPool builds a pool from the given origin URLs. opts.Policy
+// selects round_robin (default), least_conn, ip_hash, or random.
+func NewUpstreamPool(origins []string, opts UpstreamPoolOptions) (*UpstreamPool, error) {
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("at least one origin is required")
+	}
+	opts.applyDefaults()
+
+	pool := &UpstreamPool{opts: opts, stopCh: make(chan struct{})}
+	for _, raw := range origins {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin URL %q: %v", raw, err)
+		}
+		pool.upstreams = append(pool.upstreams, newUpstream(u))
+	}
+	return pool, nil
+}
+
+// LoadUpstreamsFile reads a YAML file of the form:
+//
+//	upstreams:
+//	  - http://origin-a:8080
+//	  - http://origin-b:8080
+func LoadUpstreamsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read upstreams file: %v", err)
+	}
+
+	var parsed struct {
+		Upstreams []string `yaml:"upstreams"`
+	}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parse upstreams file: %v", err)
+	}
+	return parsed.Upstreams, nil
+}
+
+// Select picks a healthy upstream per the configured policy, skipping any
+// upstream whose URL host is in excluded (used for cross-retry exclusion).
+func (p *UpstreamPool) Select(req *http.Request, excluded map[string]bool) *Upstream {
+	var candidates []*Upstream
+	for _, u := range p.upstreams {
+		if !u.Healthy.Load() {
+			continue
+		}
+		if excluded != nil && excluded[u.URL.Host] {
+			continue
+		}
+		candidates = append(candidates, u)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.opts.Policy {
+	case "least_conn":
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if u.InFlight.Load() < best.InFlight.Load() {
+				best = u
+			}
+		}
+		return best
+	case "ip_hash":
+		h := fnv.New32a()
+		h.Write([]byte(req.RemoteAddr))
+		return candidates[int(h.Sum32())%len(candidates)]
+	case "random":
+		return candidates[rand.Intn(len(candidates))]
+	default: // round_robin
+		i := p.counter.Add(1) - 1
+		return candidates[int(i)%len(candidates)]
+	}
+}
+
+func (p *UpstreamPool) RecordSuccess(u *Upstream) {
+	u.recordSuccess()
+}
+
+func (p *UpstreamPool) RecordFailure(u *Upstream, err error) {
+	u.recordFailure(err, p.opts.UnhealthyThreshold, p.opts.UnhealthyWindow)
+}
+
+func (p *UpstreamPool) MaxRetries() int {
+	return p.opts.MaxRetries
+}
+
+// StartHealthChecks runs one active-checker goroutine per upstream, GETing
+// HealthPath every HealthInterval and flipping Healthy based on the
+// response status code.
+func (p *UpstreamPool) StartHealthChecks() {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, u := range p.upstreams {
+		u := u
+		go func() {
+			ticker := time.NewTicker(p.opts.HealthInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.checkOnce(client, u)
+				case <-p.stopCh:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (p *UpstreamPool) checkOnce(client *http.Client, u *Upstream) {
+	target := *u.URL
+	target.Path = p.opts.HealthPath
+
+	resp, err := client.Get(target.String())
+	if err != nil {
+		u.mu.Lock()
+		u.lastError = err.Error()
+		u.mu.Unlock()
+		u.Healthy.Store(false)
+		return
+	}
+	defer resp.Body.Close()
+
+	u.Healthy.Store(resp.StatusCode >= 200 && resp.StatusCode < 500)
+}
+
+func (p *UpstreamPool) Stop() {
+	close(p.stopCh)
+}
+
+// UpstreamStatus is a point-in-time snapshot of one upstream, used by the
+// TUI's upstreams pane.
+type UpstreamStatus struct {
+	URL       string
+	Healthy   bool
+	InFlight  int64
+	LastError string
+}
+
+func (p *UpstreamPool) Snapshot() []UpstreamStatus {
+	statuses := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		statuses = append(statuses, UpstreamStatus{
+			URL:       u.URL.String(),
+			Healthy:   u.Healthy.Load(),
+			InFlight:  u.InFlight.Load(),
+			LastError: u.LastError(),
+		})
+	}
+	return statuses
+}
+
+func backoffDuration(attempt int) time.Duration {
+	d := time.Duration(1<<uint(attempt)) * 100 * time.Millisecond
+	if d > 2*time.Second {
+		d = 2 * time.Second
+	}
+	return d
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUpstreamPoolRoundRobin(t *testing.T) {
+	pool, err := NewUpstreamPool([]string{"http://a.example", "http://b.example"}, UpstreamPoolOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost/test", nil)
+
+	first := pool.Select(req, nil)
+	second := pool.Select(req, nil)
+	third := pool.Select(req, nil)
+
+	if first.URL.Host == second.URL.Host {
+		t.Error("Expected round_robin to alternate upstreams")
+	}
+	if first.URL.Host != third.URL.Host {
+		t.Error("Expected round_robin to cycle back to the first upstream")
+	}
+}
+
+func TestUpstreamPoolSkipsUnhealthy(t *testing.T) {
+	pool, _ := NewUpstreamPool([]string{"http://a.example", "http://b.example"}, UpstreamPoolOptions{})
+	pool.upstreams[0].Healthy.Store(false)
+
+	req, _ := http.NewRequest("GET", "http://localhost/test", nil)
+	for i := 0; i < 3; i++ {
+		selected := pool.Select(req, nil)
+		if selected.URL.Host != "b.example" {
+			t.Errorf("Expected unhealthy upstream to be skipped, got %s", selected.URL.Host)
+		}
+	}
+}
+
+func TestUpstreamPoolLeastConn(t *testing.T) {
+	pool, _ := NewUpstreamPool([]string{"http://a.example", "http://b.example"}, UpstreamPoolOptions{Policy: "least_conn"})
+	pool.upstreams[0].InFlight.Store(5)
+
+	req, _ := http.NewRequest("GET", "http://localhost/test", nil)
+	selected := pool.Select(req, nil)
+	if selected.URL.Host != "b.example" {
+		t.Errorf("Expected least_conn to pick the upstream with fewer in-flight requests, got %s", selected.URL.Host)
+	}
+}
+
+func TestUpstreamPoolIPHashIsDeterministic(t *testing.T) {
+	pool, _ := NewUpstreamPool([]string{"http://a.example", "http://b.example"}, UpstreamPoolOptions{Policy: "ip_hash"})
+
+	req, _ := http.NewRequest("GET", "http://localhost/test", nil)
+	req.RemoteAddr = "203.0.113.7:54321"
+
+	first := pool.Select(req, nil)
+	second := pool.Select(req, nil)
+	if first.URL.Host != second.URL.Host {
+		t.Error("Expected ip_hash to consistently route the same client to the same upstream")
+	}
+}
+
+func TestUpstreamPassiveFailureTripsUnhealthy(t *testing.T) {
+	pool, _ := NewUpstreamPool([]string{"http://a.example"}, UpstreamPoolOptions{UnhealthyThreshold: 2, UnhealthyWindow: time.Minute})
+	upstream := pool.upstreams[0]
+
+	pool.RecordFailure(upstream, nil)
+	if !upstream.Healthy.Load() {
+		t.Error("Expected upstream to still be healthy after one failure")
+	}
+
+	pool.RecordFailure(upstream, nil)
+	if upstream.Healthy.Load() {
+		t.Error("Expected upstream to be marked unhealthy after hitting the threshold")
+	}
+}
+
+func TestLoadUpstreamsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/upstreams.yaml"
+	contents := "upstreams:\n  - http://origin-a:8080\n  - http://origin-b:8080\n"
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("Failed to write temp upstreams file: %v", err)
+	}
+
+	origins, err := LoadUpstreamsFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(origins) != 2 || origins[0] != "http://origin-a:8080" || origins[1] != "http://origin-b:8080" {
+		t.Errorf("Unexpected origins parsed: %v", origins)
+	}
+}
+
+func TestUpstreamPoolActiveHealthCheck(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	pool, _ := NewUpstreamPool([]string{upstream.URL}, UpstreamPoolOptions{HealthPath: "/"})
+	pool.checkOnce(&http.Client{Timeout: time.Second}, pool.upstreams[0])
+
+	if pool.upstreams[0].Healthy.Load() {
+		t.Error("Expected a 503 health check response to mark the upstream unhealthy")
+	}
+}